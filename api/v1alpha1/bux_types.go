@@ -17,7 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // ConditionReconciled is reconciled
@@ -32,6 +35,150 @@ const ReconciledReasonError = "Error"
 // ReconcileCompleteMessage is when the reconciling is complete
 const ReconcileCompleteMessage = "Reconcile complete"
 
+// ReconciledReasonPaused is when spec.paused stops the controller from changing the objects
+const ReconciledReasonPaused = "Paused"
+
+// ReconcilePausedMessage is when the reconciling is paused
+const ReconcilePausedMessage = "Reconcile paused, the objects of the Bux are left as they are"
+
+// ConditionReady is whether the workloads of the Bux are available and its
+// certificates issued
+const ConditionReady = "Ready"
+
+// ReadyReasonAvailable is when every workload is available
+const ReadyReasonAvailable = "Available"
+
+// ReadyReasonUnavailable is when a workload or certificate is not ready yet
+const ReadyReasonUnavailable = "Unavailable"
+
+// ConditionMaintenance is whether bux-server is scaled down for maintenance
+const ConditionMaintenance = "Maintenance"
+
+// MaintenanceReasonEnabled is when bux-server is down and the datastore keeps running
+const MaintenanceReasonEnabled = "Enabled"
+
+// MaintenanceReasonDisabled is when bux-server is serving
+const MaintenanceReasonDisabled = "Disabled"
+
+// ConditionPostgresqlStorageReady is whether the postgresql volume matches the spec
+const ConditionPostgresqlStorageReady = "PostgresqlStorageReady"
+
+// ConditionConsoleMongoStorageReady is whether the console mongo volume matches the spec
+const ConditionConsoleMongoStorageReady = "ConsoleMongoStorageReady"
+
+// StorageReasonReady is when the volume has the requested size
+const StorageReasonReady = "Ready"
+
+// StorageReasonResizing is when the volume is being expanded
+const StorageReasonResizing = "Resizing"
+
+// StorageReasonShrinkRefused is when the requested size is below the current size
+const StorageReasonShrinkRefused = "ShrinkRefused"
+
+// StorageReasonExpansionUnsupported is when the storage class cannot expand the volume
+const StorageReasonExpansionUnsupported = "ExpansionUnsupported"
+
+// StorageReasonClassChangeRefused is when the requested storage class is not
+// the class of the existing volume
+const StorageReasonClassChangeRefused = "ClassChangeRefused"
+
+// ConditionRestored is whether the datastore was restored from spec.restoreFrom
+const ConditionRestored = "Restored"
+
+// RestoreReasonRunning is when the restore job has not finished yet
+const RestoreReasonRunning = "Running"
+
+// RestoreReasonSucceeded is when the restore job completed
+const RestoreReasonSucceeded = "Succeeded"
+
+// RestoreReasonFailed is when the restore job failed
+const RestoreReasonFailed = "Failed"
+
+// RestoreReasonSkipped is when restoreFrom was set on an already deployed Bux
+const RestoreReasonSkipped = "Skipped"
+
+// ConditionMigrated is whether the datastore migrations ran for the bux-server image
+const ConditionMigrated = "Migrated"
+
+// MigrationReasonRunning is when the migration job has not finished yet
+const MigrationReasonRunning = "Running"
+
+// MigrationReasonSucceeded is when the migration job completed
+const MigrationReasonSucceeded = "Succeeded"
+
+// MigrationReasonFailed is when the migration job failed and the rollout is held
+const MigrationReasonFailed = "Failed"
+
+// ConditionUpgraded is whether bux-server runs spec.version
+const ConditionUpgraded = "Upgraded"
+
+// UpgradeReasonComplete is when bux-server runs the requested version
+const UpgradeReasonComplete = "Complete"
+
+// UpgradeReasonInProgress is when an upgrade is going through its phases
+const UpgradeReasonInProgress = "InProgress"
+
+// UpgradeReasonDowngradeRefused is when the requested version has an older schema
+const UpgradeReasonDowngradeRefused = "DowngradeRefused"
+
+// UpgradeReasonBackupFailed is when the pre-upgrade backup failed
+const UpgradeReasonBackupFailed = "BackupFailed"
+
+// UpgradeReasonRolledBack is when the new pods did not become ready in time
+const UpgradeReasonRolledBack = "RolledBack"
+
+// UpgradePhase is a step of a bux-server upgrade
+type UpgradePhase string
+
+const (
+	// UpgradePhaseBackingUp is taking the pre-upgrade backup
+	UpgradePhaseBackingUp UpgradePhase = "BackingUp"
+
+	// UpgradePhaseMigrating is running the migrations of the new version
+	UpgradePhaseMigrating UpgradePhase = "Migrating"
+
+	// UpgradePhaseRollingOut is waiting for the new pods to become ready
+	UpgradePhaseRollingOut UpgradePhase = "RollingOut"
+
+	// UpgradePhaseBlocked is when the upgrade will not be attempted
+	UpgradePhaseBlocked UpgradePhase = "Blocked"
+
+	// UpgradePhaseRolledBack is when the previous version was restored
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+)
+
+// StepPhase is the outcome of a reconcile step
+type StepPhase string
+
+const (
+	// StepPhaseDone is when the objects of the step match the spec
+	StepPhaseDone StepPhase = "Done"
+
+	// StepPhaseSkipped is when the spec does not enable the step
+	StepPhaseSkipped StepPhase = "Skipped"
+
+	// StepPhaseWaiting is when the step waits on the cluster, e.g. for a job
+	StepPhaseWaiting StepPhase = "Waiting"
+
+	// StepPhaseBlocked is when a step it comes after is waiting or failed
+	StepPhaseBlocked StepPhase = "Blocked"
+
+	// StepPhaseFailed is when the step returned an error
+	StepPhaseFailed StepPhase = "Failed"
+)
+
+// Naming is how the names of the objects of a Bux are derived
+type Naming string
+
+const (
+	// NamingInstance prefixes the objects with the name of the Bux
+	NamingInstance Naming = "Instance"
+
+	// NamingLegacy is the fixed names of the Buxes created before names were
+	// derived from the Bux, their volumes can't be renamed
+	NamingLegacy Naming = "Legacy"
+)
+
 // TODO: this should just be the bux config type, but its missing DeepCopy
 // Functions or something like that idk:
 // https://github.com/operator-framework/operator-sdk/issues/612
@@ -61,18 +208,252 @@ type AgentConfig struct {
 	URL string `json:"url"`
 }
 
-// BuxSpec defines the desired state of Bux
+// StorageConfig is the persistent volume configuration
+type StorageConfig struct {
+	StorageClassName *string            `json:"storageClassName,omitempty"`
+	Size             *resource.Quantity `json:"size,omitempty"`
+	// WhenDisabled is what happens to the volume when its component is
+	// turned off, e.g. the console mongo or redis volume, defaults to Retain
+	// +kubebuilder:validation:Enum=Retain;Delete
+	WhenDisabled VolumeRetentionPolicy `json:"whenDisabled,omitempty"`
+}
+
+// VolumeRetentionPolicy is what happens to the volume of a disabled component
+type VolumeRetentionPolicy string
+
+const (
+	// VolumeRetentionPolicyRetain keeps the volume, it is used again when the
+	// component is turned back on
+	VolumeRetentionPolicyRetain VolumeRetentionPolicy = "Retain"
+
+	// VolumeRetentionPolicyDelete deletes the volume with the component
+	VolumeRetentionPolicyDelete VolumeRetentionPolicy = "Delete"
+)
+
+// ServiceAccountConfig configures the ServiceAccount of a component
+type ServiceAccountConfig struct {
+	// Annotations are added to the ServiceAccount, e.g. for IRSA or workload identity
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// AutomountServiceAccountToken defaults to false, none of the pods use the
+	// Kubernetes API
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+}
+
+// PodConfig overrides the defaults of the pods of a component, the resources
+// and probes apply to its main container
+type PodConfig struct {
+	Resources                 *corev1.ResourceRequirements      `json:"resources,omitempty"`
+	LivenessProbe             *corev1.Probe                     `json:"livenessProbe,omitempty"`
+	ReadinessProbe            *corev1.Probe                     `json:"readinessProbe,omitempty"`
+	StartupProbe              *corev1.Probe                     `json:"startupProbe,omitempty"`
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	Affinity                  *corev1.Affinity                  `json:"affinity,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	ServiceAccount            *ServiceAccountConfig             `json:"serviceAccount,omitempty"`
+	// PodTemplatePatch is a strategic merge patch applied to the pod template
+	// after all the other settings, e.g. to add a sidecar or volumes
+	// +kubebuilder:pruning:PreserveUnknownFields
+	PodTemplatePatch *runtime.RawExtension `json:"podTemplatePatch,omitempty"`
+}
+
+// ServerConfig is the bux-server pod configuration
+type ServerConfig struct {
+	PodConfig `json:",inline"`
+}
+
+// ConsoleAppConfig is the bux-console pod configuration
+type ConsoleAppConfig struct {
+	PodConfig `json:",inline"`
+}
+
+// PostgresqlConfig is the in-cluster postgresql configuration
+type PostgresqlConfig struct {
+	PodConfig `json:",inline"`
+	Storage   *StorageConfig `json:"storage,omitempty"`
+}
+
+// ConsoleMongoConfig is the bux-console mongodb configuration
+type ConsoleMongoConfig struct {
+	PodConfig `json:",inline"`
+	Storage   *StorageConfig `json:"storage,omitempty"`
+}
+
+// RedisConfig is the redis configuration, the in-cluster redis is used
+// unless an external URL is set
+type RedisConfig struct {
+	// Storage is only used when the redis statefulset is created
+	Storage *StorageConfig `json:"storage,omitempty"`
+	// URL of an external redis, e.g. redis://redis.example.com:6379
+	URL string `json:"url,omitempty"`
+	// PasswordSecret is the key of a Secret with the password of the external
+	// redis, the password has to be URL safe
+	PasswordSecret *corev1.SecretKeySelector `json:"passwordSecret,omitempty"`
+	UseTLS         bool                      `json:"useTLS,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MaxActiveConnections *int `json:"maxActiveConnections,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MaxIdleConnections    *int             `json:"maxIdleConnections,omitempty"`
+	MaxConnectionLifetime *metav1.Duration `json:"maxConnectionLifetime,omitempty"`
+	MaxIdleTimeout        *metav1.Duration `json:"maxIdleTimeout,omitempty"`
+}
+
+// S3Config is a location in S3 compatible object storage
+type S3Config struct {
+	// Endpoint is the S3 API endpoint, leave empty for AWS
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Bucket   string `json:"bucket"`
+	// Prefix defaults to <namespace>/<name> of the Bux
+	Prefix string `json:"prefix,omitempty"`
+	// CredentialsSecret is the name of a secret with the AWS_ACCESS_KEY_ID
+	// and AWS_SECRET_ACCESS_KEY keys
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// BackupConfig is the scheduled datastore backup configuration
+type BackupConfig struct {
+	// Schedule is a cron schedule, e.g. "0 3 * * *"
+	Schedule string    `json:"schedule"`
+	S3       *S3Config `json:"s3"`
+	// Retention is the number of backups to keep, defaults to 7
+	Retention      *int32                `json:"retention,omitempty"`
+	Suspend        bool                  `json:"suspend,omitempty"`
+	ServiceAccount *ServiceAccountConfig `json:"serviceAccount,omitempty"`
+}
+
+// RestoreConfig is the backup a new Bux is restored from
+type RestoreConfig struct {
+	S3 *S3Config `json:"s3"`
+	// Key is the object to restore, defaults to the newest backup under the prefix
+	Key            string                `json:"key,omitempty"`
+	ServiceAccount *ServiceAccountConfig `json:"serviceAccount,omitempty"`
+}
+
+// Profile is the kind of bux instance
+type Profile string
+
+const (
+	// ProfileStandard runs bux-server with redis and can scale out
+	ProfileStandard Profile = "standard"
+
+	// ProfileLite runs a single bux-server pod with its cache and tasks in
+	// memory, without redis
+	ProfileLite Profile = "lite"
+)
+
+// AutoscalingConfig scales bux-server with a HorizontalPodAutoscaler
+type AutoscalingConfig struct {
+	// MinReplicas defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetCPUUtilizationPercentage defaults to 80 when no target is set
+	// +kubebuilder:validation:Minimum=1
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+}
+
+// NetworkPolicyConfig isolates the workloads of a Bux with NetworkPolicies
+type NetworkPolicyConfig struct {
+	Enabled bool `json:"enabled"`
+	// IngressNamespace is the namespace of the ingress controller, the only
+	// one that can reach bux-server, defaults to ingress-nginx
+	IngressNamespace string `json:"ingressNamespace,omitempty"`
+}
+
+// MaintenanceResponse is served with a 503 through the ingress of bux-server
+// while it is down for maintenance
+type MaintenanceResponse struct {
+	Body string `json:"body"`
+	// ContentType defaults to application/json
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// MaintenanceConfig scales bux-server down, the datastore and redis keep running
+type MaintenanceConfig struct {
+	Enabled bool `json:"enabled"`
+	// Response is served instead of bux-server, otherwise the ingress has no
+	// backend to route to
+	Response *MaintenanceResponse `json:"response,omitempty"`
+}
+
+// BuxSpec defines the desired state of Bux, the fields it leaves empty default
+// to those of the BuxPlatform
 type BuxSpec struct {
-	Configuration *BuxConfig `json:"configuration"`
-	Domain        string     `json:"domain"`
-	ClusterIssuer string     `json:"clusterIssuer"`
-	Console       bool       `json:"console"`
+	Configuration *BuxConfig          `json:"configuration"`
+	Domain        string              `json:"domain,omitempty"`
+	ClusterIssuer string              `json:"clusterIssuer,omitempty"`
+	Console       bool                `json:"console"`
+	Server        *ServerConfig       `json:"server,omitempty"`
+	ConsoleApp    *ConsoleAppConfig   `json:"consoleApp,omitempty"`
+	Postgresql    *PostgresqlConfig   `json:"postgresql,omitempty"`
+	ConsoleMongo  *ConsoleMongoConfig `json:"consoleMongo,omitempty"`
+	Redis         *RedisConfig        `json:"redis,omitempty"`
+	Backup        *BackupConfig       `json:"backup,omitempty"`
+	// RestoreFrom is only used when the Bux is first created
+	RestoreFrom *RestoreConfig `json:"restoreFrom,omitempty"`
+	// Version is the bux-server image tag, defaults to latest
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`
+	Version string `json:"version,omitempty"`
+	// UpgradeTimeout is how long new pods have to become ready before an
+	// upgrade is rolled back, defaults to 10m
+	UpgradeTimeout *metav1.Duration `json:"upgradeTimeout,omitempty"`
+	// Replicas of bux-server, defaults to 1 and is ignored when autoscaling
+	// +kubebuilder:validation:Minimum=0
+	Replicas      *int32               `json:"replicas,omitempty"`
+	Autoscaling   *AutoscalingConfig   `json:"autoscaling,omitempty"`
+	NetworkPolicy *NetworkPolicyConfig `json:"networkPolicy,omitempty"`
+	// Profile defaults to standard
+	// +kubebuilder:validation:Enum=standard;lite
+	Profile Profile `json:"profile,omitempty"`
+	// ImageRegistry is pulled from instead of the registries of the images,
+	// e.g. a mirror, defaults to that of the BuxPlatform
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// Paused stops the controller from changing the objects of the Bux, e.g.
+	// while they are edited by hand, its status is still updated
+	Paused      bool               `json:"paused,omitempty"`
+	Maintenance *MaintenanceConfig `json:"maintenance,omitempty"`
+}
+
+// BackupStatus is the observed state of the scheduled backups
+type BackupStatus struct {
+	LastScheduleTime   *metav1.Time `json:"lastScheduleTime,omitempty"`
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+}
+
+// UpgradeStatus is the progress of a bux-server upgrade
+type UpgradeStatus struct {
+	FromVersion    string       `json:"fromVersion"`
+	ToVersion      string       `json:"toVersion"`
+	Phase          UpgradePhase `json:"phase"`
+	PhaseStartedAt metav1.Time  `json:"phaseStartedAt"`
+}
+
+// StepStatus is the outcome of a reconcile step in the last reconcile
+type StepStatus struct {
+	Name    string    `json:"name"`
+	Phase   StepPhase `json:"phase"`
+	Message string    `json:"message,omitempty"`
 }
 
 // BuxStatus defines the observed state of Bux
 type BuxStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 	Route      string             `json:"route,omitempty"`
+	Backup     *BackupStatus      `json:"backup,omitempty"`
+	// Version is the bux-server version that is rolled out
+	Version string         `json:"version,omitempty"`
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+	// Naming is recorded the first time the Bux is reconciled, and copied
+	// from the getbux.io/naming annotation after that
+	Naming Naming `json:"naming,omitempty"`
+	// Steps are the outcomes of the reconcile steps of the last reconcile
+	// +listType=map
+	// +listMapKey=name
+	Steps []StepStatus `json:"steps,omitempty"`
 }
 
 // +kubebuilder:object:root=true
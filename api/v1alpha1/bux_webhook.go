@@ -0,0 +1,194 @@
+/*
+Copyright 2022 Dylan Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// SetupWebhookWithManager registers the Bux webhooks with the manager
+func (r *Bux) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-server-getbux-io-v1alpha1-bux,mutating=false,failurePolicy=fail,sideEffects=None,groups=server.getbux.io,resources=buxes,verbs=create;update,versions=v1alpha1,name=vbux.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Bux{}
+
+// ValidateCreate implements webhook.Validator
+func (r *Bux) ValidateCreate() error {
+	return r.validateBux()
+}
+
+// ValidateUpdate implements webhook.Validator
+func (r *Bux) ValidateUpdate(old runtime.Object) error {
+	if err := r.validateBux(); err != nil {
+		return err
+	}
+	oldBux, ok := old.(*Bux)
+	if !ok {
+		return nil
+	}
+	allErrs := validateStorageClassChanges(&oldBux.Spec, &r.Spec, field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Bux"}, r.Name, allErrs)
+}
+
+// ValidateDelete implements webhook.Validator
+func (r *Bux) ValidateDelete() error {
+	return nil
+}
+
+func (r *Bux) validateBux() error {
+	var allErrs field.ErrorList
+	spec := field.NewPath("spec")
+	if r.Spec.Server != nil {
+		allErrs = append(allErrs, validatePodConfig(&r.Spec.Server.PodConfig, spec.Child("server"))...)
+	}
+	if r.Spec.ConsoleApp != nil {
+		allErrs = append(allErrs, validatePodConfig(&r.Spec.ConsoleApp.PodConfig, spec.Child("consoleApp"))...)
+	}
+	if r.Spec.Postgresql != nil {
+		allErrs = append(allErrs, validatePodConfig(&r.Spec.Postgresql.PodConfig, spec.Child("postgresql"))...)
+	}
+	if r.Spec.ConsoleMongo != nil {
+		allErrs = append(allErrs, validatePodConfig(&r.Spec.ConsoleMongo.PodConfig, spec.Child("consoleMongo"))...)
+	}
+	if r.Spec.Profile == ProfileLite {
+		allErrs = append(allErrs, ValidateLiteProfile(&r.Spec, spec)...)
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Bux"}, r.Name, allErrs)
+}
+
+// ValidateLiteProfile refuses the settings that need more than one bux-server
+// pod or redis, the lite profile keeps its state in memory. The controller
+// validates with it too, for the Buxes applied without the webhook.
+func ValidateLiteProfile(spec *BuxSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if spec.Replicas != nil && *spec.Replicas > 1 {
+		allErrs = append(allErrs, field.Invalid(path.Child("replicas"), *spec.Replicas,
+			"the lite profile runs a single replica"))
+	}
+	if spec.Autoscaling != nil {
+		allErrs = append(allErrs, field.Forbidden(path.Child("autoscaling"),
+			"the lite profile runs a single replica"))
+	}
+	if spec.Redis != nil && spec.Redis.URL != "" {
+		allErrs = append(allErrs, field.Forbidden(path.Child("redis", "url"),
+			"the lite profile does not use redis"))
+	}
+	return allErrs
+}
+
+// validateStorageClassChanges refuses to change a storage class that was set,
+// the class of an existing claim can't change. Setting one where there was none
+// is left to the controller, which knows whether the claim exists.
+func validateStorageClassChanges(old, spec *BuxSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, volume := range []struct {
+		name    string
+		storage func(*BuxSpec) *StorageConfig
+	}{
+		{name: "postgresql", storage: func(spec *BuxSpec) *StorageConfig {
+			if spec.Postgresql == nil {
+				return nil
+			}
+			return spec.Postgresql.Storage
+		}},
+		{name: "consoleMongo", storage: func(spec *BuxSpec) *StorageConfig {
+			if spec.ConsoleMongo == nil {
+				return nil
+			}
+			return spec.ConsoleMongo.Storage
+		}},
+		{name: "redis", storage: func(spec *BuxSpec) *StorageConfig {
+			if spec.Redis == nil {
+				return nil
+			}
+			return spec.Redis.Storage
+		}},
+	} {
+		oldClass, class := storageClassName(volume.storage(old)), storageClassName(volume.storage(spec))
+		if oldClass != "" && oldClass != class {
+			allErrs = append(allErrs, field.Invalid(path.Child(volume.name, "storage", "storageClassName"), class,
+				"the storage class of a volume can't change"))
+		}
+	}
+	return allErrs
+}
+
+// storageClassName is the storage class of storage, empty for the default class
+func storageClassName(storage *StorageConfig) string {
+	if storage == nil || storage.StorageClassName == nil {
+		return ""
+	}
+	return *storage.StorageClassName
+}
+
+func validatePodConfig(pod *PodConfig, path *field.Path) field.ErrorList {
+	if pod.PodTemplatePatch == nil {
+		return nil
+	}
+	// The patch has to apply to any template, so try it on an empty one
+	if err := PatchPodTemplate(&corev1.PodTemplateSpec{}, pod.PodTemplatePatch); err != nil {
+		return field.ErrorList{
+			field.Invalid(path.Child("podTemplatePatch"), string(pod.PodTemplatePatch.Raw), err.Error()),
+		}
+	}
+	return nil
+}
+
+// PatchPodTemplate applies the strategic merge patch to template, fields
+// that don't exist on a pod template are an error
+func PatchPodTemplate(template *corev1.PodTemplateSpec, patch *runtime.RawExtension) error {
+	if patch == nil || len(patch.Raw) == 0 {
+		return nil
+	}
+	original, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch.Raw, corev1.PodTemplateSpec{})
+	if err != nil {
+		return err
+	}
+	result := corev1.PodTemplateSpec{}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&result); err != nil {
+		return err
+	}
+	*template = result
+	return nil
+}
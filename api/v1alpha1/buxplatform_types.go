@@ -0,0 +1,74 @@
+/*
+Copyright 2022 Dylan Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BuxPlatformName is the name of the BuxPlatform the controller uses, others
+// are ignored
+const BuxPlatformName = "default"
+
+// ResourcePresets are the default resources of the main container of each
+// component
+type ResourcePresets struct {
+	Server       *corev1.ResourceRequirements `json:"server,omitempty"`
+	ConsoleApp   *corev1.ResourceRequirements `json:"consoleApp,omitempty"`
+	Postgresql   *corev1.ResourceRequirements `json:"postgresql,omitempty"`
+	ConsoleMongo *corev1.ResourceRequirements `json:"consoleMongo,omitempty"`
+}
+
+// BuxPlatformSpec are the defaults of every Bux in the cluster, the fields a
+// Bux sets itself take precedence
+type BuxPlatformSpec struct {
+	// Domain is the base domain the Buxes are served under
+	Domain        string `json:"domain,omitempty"`
+	ClusterIssuer string `json:"clusterIssuer,omitempty"`
+	// ImageRegistry is pulled from instead of the registries of the images,
+	// e.g. a mirror
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// StorageClassName of the volumes of postgresql, mongodb and redis
+	StorageClassName *string          `json:"storageClassName,omitempty"`
+	Resources        *ResourcePresets `json:"resources,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// BuxPlatform is the Schema for the buxplatforms API, the platform-wide
+// defaults of the Buxes. Only the BuxPlatform named default is used.
+type BuxPlatform struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BuxPlatformSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BuxPlatformList contains a list of BuxPlatform
+type BuxPlatformList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BuxPlatform `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BuxPlatform{}, &BuxPlatformList{})
+}
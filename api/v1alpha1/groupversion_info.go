@@ -34,9 +34,19 @@ var (
 	// Kind is the kind that we support
 	Kind = "Bux"
 
+	// PlatformKind is the kind of the platform-wide defaults of the Buxes
+	PlatformKind = "BuxPlatform"
+
 	// AddToScheme adds the types in this group-version to the given scheme.
 	AddToScheme = SchemeBuilder.AddToScheme
 
 	// BuxLabel is the label we are adding to all resources we create
 	BuxLabel = "getbux.io/server"
+
+	// InstanceLabel is the name of the Bux, set on its resources and pods
+	InstanceLabel = "app.kubernetes.io/instance"
+
+	// NamingAnnotation records the Naming of a Bux in its metadata, which
+	// unlike the status survives a restore or a replace of the Bux
+	NamingAnnotation = "getbux.io/naming"
 )
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// fieldManager owns the fields of the objects the controller applies
+const fieldManager = "bux-kube-controller"
+
+// apply renders obj with f and applies it server-side. The controller only owns
+// the fields it renders, the fields other controllers set, like the replicas of
+// an autoscaler or injected sidecars, and the defaults are left alone, and an
+// object that already matches is not written. obj is built from scratch, with
+// just its name, namespace and labels, and holds the applied object afterwards.
+func (r *BuxRequest) apply(obj client.Object, f func() error) error {
+	if err := f(); err != nil {
+		return err
+	}
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	if svc, ok := obj.(*corev1.Service); ok {
+		if err := r.replaceStaleSelector(svc); err != nil {
+			return err
+		}
+	}
+	return r.Patch(r.Context, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// replaceStaleSelector drops the selector keys of an existing Service that svc
+// doesn't render. The Services written with CreateOrUpdate before the
+// controller applied its objects have their selector owned by the Update
+// manager, so applying the new selector merges it with the old keys, and the
+// Service then selects no pods. A single Update replaces the whole selector,
+// after which the applied one is all there is.
+func (r *BuxRequest) replaceStaleSelector(svc *corev1.Service) error {
+	current := corev1.Service{}
+	err := r.Get(r.Context, client.ObjectKeyFromObject(svc), &current)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	stale := false
+	for key := range current.Spec.Selector {
+		if _, ok := svc.Spec.Selector[key]; !ok {
+			stale = true
+		}
+	}
+	if !stale {
+		return nil
+	}
+	current.Spec.Selector = make(map[string]string, len(svc.Spec.Selector))
+	for key, value := range svc.Spec.Selector {
+		current.Spec.Selector[key] = value
+	}
+	return r.Update(r.Context, &current, client.FieldOwner(fieldManager))
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+)
+
+var _ = Describe("Server-side apply", func() {
+	It("does not write the children of a Bux that is up to date", func() {
+		ctx := context.Background()
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "apply-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+		bux := &serverv1alpha1.Bux{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: namespace.Name},
+			Spec: serverv1alpha1.BuxSpec{
+				Configuration: &serverv1alpha1.BuxConfig{
+					Paymail:   &serverv1alpha1.PaymailConfig{Enabled: true},
+					Datastore: "postgresql",
+				},
+				Domain:  "example.com",
+				Console: true,
+			},
+		}
+		Expect(k8sClient.Create(ctx, bux)).To(Succeed())
+
+		reconciler := &BuxReconciler{
+			Client:    k8sClient,
+			APIReader: k8sClient,
+			Scheme:    k8sClient.Scheme(),
+		}
+		request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(bux)}
+		_, err := reconciler.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+		before := childVersions(ctx, namespace.Name)
+		Expect(before).To(HaveKey("Deployment/shop"))
+		Expect(before).To(HaveKey("StatefulSet/shop-postgresql"))
+
+		_, err = reconciler.Reconcile(ctx, request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(childVersions(ctx, namespace.Name)).To(Equal(before))
+	})
+})
+
+// childVersions are the resource versions of the objects of the Buxes in
+// namespace, by kind and name
+func childVersions(ctx context.Context, namespace string) map[string]string {
+	lists := map[string]client.ObjectList{
+		"Deployment":            &appsv1.DeploymentList{},
+		"StatefulSet":           &appsv1.StatefulSetList{},
+		"Service":               &corev1.ServiceList{},
+		"ConfigMap":             &corev1.ConfigMapList{},
+		"ServiceAccount":        &corev1.ServiceAccountList{},
+		"PersistentVolumeClaim": &corev1.PersistentVolumeClaimList{},
+		"Ingress":               &networkingv1.IngressList{},
+		"PodDisruptionBudget":   &policyv1.PodDisruptionBudgetList{},
+	}
+	versions := make(map[string]string)
+	for kind, list := range lists {
+		Expect(k8sClient.List(ctx, list, client.InNamespace(namespace),
+			client.HasLabels{serverv1alpha1.BuxLabel})).To(Succeed())
+		items, err := apimeta.ExtractList(list)
+		Expect(err).NotTo(HaveOccurred())
+		for _, item := range items {
+			obj := item.(client.Object)
+			versions[kind+"/"+obj.GetName()] = obj.GetResourceVersion()
+		}
+	}
+	return versions
+}
+
+func TestAutoscaledDeploymentLeavesReplicasToTheAutoscaler(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Spec: serverv1alpha1.BuxSpec{
+			Replicas: pointer.Int32Ptr(2),
+			Autoscaling: &serverv1alpha1.AutoscalingConfig{
+				MinReplicas: pointer.Int32Ptr(3),
+				MaxReplicas: 5,
+			},
+		},
+	}
+	r := fakeRequest(t, bux)
+	r.BuxStatus.Version = latestVersion
+
+	for _, test := range []struct {
+		name    string
+		current *int32
+		want    *int32
+	}{
+		{name: "new deployment", current: nil, want: nil},
+		{name: "scaled by the autoscaler", current: pointer.Int32Ptr(4), want: nil},
+		{name: "back from maintenance", current: pointer.Int32Ptr(0), want: pointer.Int32Ptr(3)},
+	} {
+		dep := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "payments"}}
+		if err := r.updateDeployment(&dep, bux, test.current); err != nil {
+			t.Fatal(err)
+		}
+		got := dep.Spec.Replicas
+		if (got == nil) != (test.want == nil) || got != nil && *got != *test.want {
+			t.Errorf("%s: replicas = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	bux.Spec.Maintenance = &serverv1alpha1.MaintenanceConfig{Enabled: true}
+	dep := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "payments"}}
+	if err := r.updateDeployment(&dep, bux, pointer.Int32Ptr(4)); err != nil {
+		t.Fatal(err)
+	}
+	if dep.Spec.Replicas == nil || *dep.Spec.Replicas != 0 {
+		t.Errorf("maintenance: replicas = %v, want 0", dep.Spec.Replicas)
+	}
+}
+
+// mergingApplyClient applies like the API server does to the Services written
+// before the controller applied its objects: the selector keys the field
+// manager doesn't own are kept
+type mergingApplyClient struct {
+	applyClient
+}
+
+func (c mergingApplyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.PatchOption,
+) error {
+	if svc, ok := obj.(*corev1.Service); ok && patch == client.Apply {
+		current := corev1.Service{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(svc), &current); err == nil {
+			for key, value := range current.Spec.Selector {
+				if _, ok := svc.Spec.Selector[key]; !ok {
+					svc.Spec.Selector[key] = value
+				}
+			}
+		}
+	}
+	return c.applyClient.Patch(ctx, obj, patch, opts...)
+}
+
+func TestUpgradedServicesSelectJustTheirPods(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Status:     serverv1alpha1.BuxStatus{Naming: serverv1alpha1.NamingLegacy},
+	}
+	names := newBuxNames(bux)
+	// the Services as CreateOrUpdate wrote them, before the selectors had the
+	// instance and component labels
+	baseline := func(name string, selector map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: bux.Namespace},
+			Spec:       corev1.ServiceSpec{Selector: selector},
+		}
+	}
+	r := fakeRequest(t, bux,
+		baseline(names.server(), map[string]string{"app": "bux"}),
+		baseline(names.datastore(), map[string]string{"app": "bux", "deployment": "bux-postgresql"}),
+		baseline(names.console(), map[string]string{"app": "bux-console"}),
+		baseline(names.consoleMongodb(), map[string]string{"app": "bux-console-mongodb"}),
+	)
+	r.Client = mergingApplyClient{r.Client.(applyClient)}
+	r.Names = names
+
+	for _, test := range []struct {
+		name      string
+		reconcile func(logr.Logger) (bool, error)
+		component component
+	}{
+		{name: names.server(), reconcile: r.ReconcileService, component: componentServer},
+		{name: names.datastore(), reconcile: r.ReconcileDatastoreService, component: componentDatastore},
+		{name: names.console(), reconcile: r.ReconcileConsoleService, component: componentConsole},
+		{name: names.consoleMongodb(), reconcile: r.ReconcileConsoleMongoService, component: componentConsoleMongo},
+	} {
+		if _, err := test.reconcile(r.Log); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		svc := corev1.Service{}
+		key := types.NamespacedName{Name: test.name, Namespace: bux.Namespace}
+		if err := r.Get(r.Context, key, &svc); err != nil {
+			t.Fatal(err)
+		}
+		if want := names.selector(test.component); !reflect.DeepEqual(svc.Spec.Selector, want) {
+			t.Errorf("%s selects %v, want %v", test.name, svc.Spec.Selector, want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// awsCliImage is used to move backups in and out of object storage
+	awsCliImage = "docker.io/amazon/aws-cli:2.7.31"
+
+	// datastoreDumpFile is the name of the pg_dump file of the backups
+	datastoreDumpFile = "bux.dump"
+
+	// defaultBackupRetention is the number of backups kept when the spec does not say
+	defaultBackupRetention = 7
+
+	// backupStatusRefreshInterval is how often the backup status is copied from the cronjob
+	backupStatusRefreshInterval = 10 * time.Minute
+)
+
+// awsCliPrelude points the aws cli at S3_ENDPOINT, when set, through "$@"
+const awsCliPrelude = `set -eu
+if [ -n "${S3_ENDPOINT}" ]; then
+  aws configure set default.s3.addressing_style path
+  set -- --endpoint-url "${S3_ENDPOINT}"
+fi
+`
+
+// backupUploadScript uploads the dump to S3 and removes the backups beyond the retention
+const backupUploadScript = awsCliPrelude + `key="${S3_PREFIX}/$(date -u +%Y%m%dT%H%M%SZ)-${BACKUP_FILE}"
+aws "$@" s3 cp "/backup/${BACKUP_FILE}" "s3://${S3_BUCKET}/${key}"
+aws "$@" s3api list-objects-v2 --bucket "${S3_BUCKET}" --prefix "${S3_PREFIX}/" \
+  --query 'sort_by(Contents, &Key)[].Key' --output text | tr '\t' '\n' | grep -e "-${BACKUP_FILE}$" \
+  | head -n "-${BACKUP_RETENTION}" | while read -r old; do
+  aws "$@" s3 rm "s3://${S3_BUCKET}/${old}"
+done
+`
+
+// ReconcileBackup is the scheduled datastore backup
+func (r *BuxRequest) ReconcileBackup(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	cronJob := batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.backup(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentBackup),
+		},
+	}
+	err := r.apply(&cronJob, func() error {
+		return r.updateBackupCronJob(&cronJob, bux)
+	})
+	if err != nil {
+		return false, err
+	}
+	r.BuxStatus.Backup = &serverv1alpha1.BackupStatus{
+		LastScheduleTime:   cronJob.Status.LastScheduleTime,
+		LastSuccessfulTime: cronJob.Status.LastSuccessfulTime,
+	}
+	r.requeueAfter(backupStatusRefreshInterval)
+	return true, nil
+}
+
+func (r *BuxRequest) updateBackupCronJob(cronJob *batchv1.CronJob, bux *serverv1alpha1.Bux) error {
+	err := controllerutil.SetControllerReference(bux, cronJob, r.Scheme)
+	if err != nil {
+		return err
+	}
+	cronJob.Spec = *defaultBackupCronJobSpec(r.Names, bux)
+	useRegistry(&cronJob.Spec.JobTemplate.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return nil
+}
+
+func defaultBackupCronJobSpec(names buxNames, bux *serverv1alpha1.Bux) *batchv1.CronJobSpec {
+	podLabels := names.labels(componentBackup)
+	retention := int32(defaultBackupRetention)
+	if bux.Spec.Backup.Retention != nil {
+		retention = *bux.Spec.Backup.Retention
+	}
+	envVars := append(s3EnvVars(bux, bux.Spec.Backup.S3),
+		corev1.EnvVar{
+			Name:  "BACKUP_FILE",
+			Value: datastoreDumpFile,
+		},
+		corev1.EnvVar{
+			Name:  "BACKUP_RETENTION",
+			Value: strconv.Itoa(int(retention)),
+		},
+	)
+	return &batchv1.CronJobSpec{
+		Schedule:                   bux.Spec.Backup.Schedule,
+		Suspend:                    pointer.BoolPtr(bux.Spec.Backup.Suspend),
+		ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+		SuccessfulJobsHistoryLimit: pointer.Int32Ptr(3),
+		FailedJobsHistoryLimit:     pointer.Int32Ptr(3),
+		JobTemplate: batchv1.JobTemplateSpec{
+			Spec: batchv1.JobSpec{
+				BackoffLimit: pointer.Int32Ptr(2),
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						CreationTimestamp: metav1.Time{},
+						Labels:            podLabels,
+					},
+					Spec: corev1.PodSpec{
+						SecurityContext:              restrictedPodSecurityContext(defaultUID),
+						ServiceAccountName:           names.backup(),
+						AutomountServiceAccountToken: automountServiceAccountToken(bux.Spec.Backup.ServiceAccount),
+						RestartPolicy:                corev1.RestartPolicyNever,
+						InitContainers: []corev1.Container{
+							*datastoreDumpContainer(names),
+						},
+						Containers: []corev1.Container{
+							{
+								Name:                     "upload",
+								Image:                    awsCliImage,
+								Command:                  []string{"/bin/sh", "-c", backupUploadScript},
+								Env:                      envVars,
+								TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+								SecurityContext:          restrictedSecurityContext(),
+								VolumeMounts: []corev1.VolumeMount{
+									{
+										Name:      "backup",
+										MountPath: "/backup",
+									},
+								},
+							},
+						},
+						Volumes: []corev1.Volume{
+							{
+								Name: "backup",
+								VolumeSource: corev1.VolumeSource{
+									EmptyDir: &corev1.EmptyDirVolumeSource{},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// datastoreDumpContainer dumps the postgresql datastore into the backup volume,
+// the validator refuses backups of a mongodb datastore
+func datastoreDumpContainer(names buxNames) *corev1.Container {
+	return &corev1.Container{
+		Name:                     "dump",
+		Image:                    postgresqlImage,
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		SecurityContext:          restrictedSecurityContext(),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "backup",
+				MountPath: "/backup",
+			},
+		},
+		Command: []string{
+			"pg_dump",
+			"-h", names.datastore(),
+			"-p", "5432",
+			"-U", "bux",
+			"-d", "bux",
+			"-Fc",
+			"-f", "/backup/" + datastoreDumpFile,
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "PGPASSWORD",
+				Value: "postgres",
+			},
+		},
+	}
+}
+
+// s3EnvVars is the environment the aws cli scripts expect for the location
+func s3EnvVars(bux *serverv1alpha1.Bux, s3 *serverv1alpha1.S3Config) []corev1.EnvVar {
+	prefix := s3.Prefix
+	if prefix == "" {
+		prefix = fmt.Sprintf("%s/%s", bux.Namespace, bux.Name)
+	}
+	secretKey := func(key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: s3.CredentialsSecret,
+				},
+				Key: key,
+			},
+		}
+	}
+	return []corev1.EnvVar{
+		{
+			Name:      "AWS_ACCESS_KEY_ID",
+			ValueFrom: secretKey("AWS_ACCESS_KEY_ID"),
+		},
+		{
+			Name:      "AWS_SECRET_ACCESS_KEY",
+			ValueFrom: secretKey("AWS_SECRET_ACCESS_KEY"),
+		},
+		{
+			Name:  "AWS_DEFAULT_REGION",
+			Value: s3.Region,
+		},
+		{
+			// the aws cli writes its configuration to the home directory
+			Name:  "HOME",
+			Value: "/tmp",
+		},
+		{
+			Name:  "S3_ENDPOINT",
+			Value: s3.Endpoint,
+		},
+		{
+			Name:  "S3_BUCKET",
+			Value: s3.Bucket,
+		},
+		{
+			Name:  "S3_PREFIX",
+			Value: prefix,
+		},
+	}
+}
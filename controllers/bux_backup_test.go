@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestBackupCronJobFollowsTheSpec(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Spec: serverv1alpha1.BuxSpec{
+			Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+			Backup: &serverv1alpha1.BackupConfig{
+				Schedule: "0 3 * * *",
+				S3:       &serverv1alpha1.S3Config{Bucket: "backups", CredentialsSecret: "s3"},
+			},
+		},
+	}
+	r := fakeRequest(t, bux)
+	key := types.NamespacedName{Name: r.Names.backup(), Namespace: bux.Namespace}
+
+	if done, err := r.ReconcileBackup(r.Log); err != nil || !done {
+		t.Fatalf("created: done = %t, %v", done, err)
+	}
+	cronJob := batchv1.CronJob{}
+	if err := r.Get(r.Context, key, &cronJob); err != nil {
+		t.Fatal(err)
+	}
+	if cronJob.Spec.Schedule != "0 3 * * *" || cronJob.Spec.Suspend == nil || *cronJob.Spec.Suspend {
+		t.Errorf("created: schedule %q, suspend %v", cronJob.Spec.Schedule, cronJob.Spec.Suspend)
+	}
+	if !metav1.IsControlledBy(&cronJob, bux) {
+		t.Error("the Bux doesn't control the cronjob")
+	}
+	if r.BuxStatus.Backup == nil || r.BuxStatus.Backup.LastScheduleTime != nil {
+		t.Errorf("created: status.backup = %v", r.BuxStatus.Backup)
+	}
+	if r.RequeueAfter != backupStatusRefreshInterval {
+		t.Errorf("requeued after %s, want %s", r.RequeueAfter, backupStatusRefreshInterval)
+	}
+
+	// a backup ran, then the backups are suspended
+	scheduled := metav1.NewTime(time.Date(2022, 10, 1, 3, 0, 0, 0, time.UTC))
+	finished := metav1.NewTime(scheduled.Add(2 * time.Minute))
+	cronJob.Status = batchv1.CronJobStatus{LastScheduleTime: &scheduled, LastSuccessfulTime: &finished}
+	if err := r.Status().Update(r.Context, &cronJob); err != nil {
+		t.Fatal(err)
+	}
+	bux.Spec.Backup.Suspend = true
+	if done, err := r.ReconcileBackup(r.Log); err != nil || !done {
+		t.Fatalf("suspended: done = %t, %v", done, err)
+	}
+	if err := r.Get(r.Context, key, &cronJob); err != nil {
+		t.Fatal(err)
+	}
+	if cronJob.Spec.Suspend == nil || !*cronJob.Spec.Suspend {
+		t.Errorf("suspended: suspend = %v", cronJob.Spec.Suspend)
+	}
+	backup := r.BuxStatus.Backup
+	if backup == nil || backup.LastScheduleTime == nil || !backup.LastScheduleTime.Equal(&scheduled) ||
+		backup.LastSuccessfulTime == nil || !backup.LastSuccessfulTime.Equal(&finished) {
+		t.Errorf("suspended: status.backup = %v, want the times of the cronjob", backup)
+	}
+}
+
+func TestBackupsNeedThePostgresqlDatastore(t *testing.T) {
+	s3 := &serverv1alpha1.S3Config{Bucket: "backups", CredentialsSecret: "s3"}
+	for _, test := range []struct {
+		name      string
+		datastore string
+		spec      serverv1alpha1.BuxSpec
+		valid     bool
+	}{
+		{name: "postgresql backup", datastore: "postgresql",
+			spec: serverv1alpha1.BuxSpec{Backup: &serverv1alpha1.BackupConfig{Schedule: "@daily", S3: s3}}, valid: true},
+		{name: "mongodb", datastore: "mongodb", valid: true},
+		{name: "mongodb backup", datastore: "mongodb",
+			spec: serverv1alpha1.BuxSpec{Backup: &serverv1alpha1.BackupConfig{Schedule: "@daily", S3: s3}}},
+		{name: "mongodb restore", datastore: "mongodb",
+			spec: serverv1alpha1.BuxSpec{RestoreFrom: &serverv1alpha1.RestoreConfig{S3: s3}}},
+	} {
+		test.spec.Configuration = &serverv1alpha1.BuxConfig{Datastore: test.datastore}
+		bux := &serverv1alpha1.Bux{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+			Spec:       test.spec,
+		}
+		r := fakeRequest(t, bux)
+		if _, err := r.Validate(r.Log); (err == nil) != test.valid {
+			t.Errorf("%s: valid = %t, want %t: %v", test.name, err == nil, test.valid, err)
+		}
+	}
+}
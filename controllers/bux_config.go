@@ -17,20 +17,17 @@ import (
 )
 
 // ReconcileConfig will reconcile configuration
-func (r *BuxReconciler) ReconcileConfig(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileConfig(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	cm := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-config",
+			Name:      r.Names.config(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentServer),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &cm, func() error {
-		return r.updateBuxConfigMap(&cm, &bux)
+	err := r.apply(&cm, func() error {
+		return r.updateBuxConfigMap(&cm, bux)
 	})
 	if err != nil {
 		return false, err
@@ -39,17 +36,17 @@ func (r *BuxReconciler) ReconcileConfig(_ logr.Logger) (bool, error) {
 }
 
 // updateBuxConfigMap will update the config
-func (r *BuxReconciler) updateBuxConfigMap(configMap *corev1.ConfigMap, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateBuxConfigMap(configMap *corev1.ConfigMap, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, configMap, r.Scheme)
 	if err != nil {
 		return err
 	}
-	configuration := defaultBuxConfig()
+	configuration := defaultBuxConfig(r.Names)
 	if bux.Spec.Configuration != nil && bux.Spec.Configuration.AdminXpub != "" {
 		configuration.Authentication.AdminKey = bux.Spec.Configuration.AdminXpub
 	}
 	if bux.Spec.Domain != "" {
-		configuration.Paymail.Domains[0] = fmt.Sprintf("%s.%s", bux.Namespace, bux.Spec.Domain)
+		configuration.Paymail.Domains[0] = r.Names.host(bux)
 	}
 
 	if bux.Spec.Configuration.Paymail != nil {
@@ -60,6 +57,16 @@ func (r *BuxReconciler) updateBuxConfigMap(configMap *corev1.ConfigMap, bux *ser
 		configuration.Paymail.SenderValidationEnabled = bux.Spec.Configuration.Paymail.SenderValidationEnabled
 	}
 
+	if bux.Spec.Redis != nil {
+		applyRedisConfig(configuration.Redis, bux.Spec.Redis)
+	}
+	if liteProfile(bux) {
+		configuration.Cachestore.Engine = cachestore.FreeCache
+		configuration.TaskManager.Factory = taskmanager.FactoryMemory
+	}
+
+	// Server pods never migrate, the migration job does it once per image by
+	// setting BUX_DATASTORE__AUTO_MIGRATE
 	var data []byte
 	if data, err = json.Marshal(configuration); err != nil {
 		return err
@@ -70,8 +77,34 @@ func (r *BuxReconciler) updateBuxConfigMap(configMap *corev1.ConfigMap, bux *ser
 	return nil
 }
 
+// liteProfile returns true if bux-server keeps its cache and tasks in memory
+func liteProfile(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Profile == serverv1alpha1.ProfileLite
+}
+
+// applyRedisConfig sets the connection settings of redis, the password of an
+// external redis is added to the URL by redisEnvVars
+func applyRedisConfig(redisConfig *config.RedisConfig, redis *serverv1alpha1.RedisConfig) {
+	if redis.URL != "" {
+		redisConfig.URL = redis.URL
+		redisConfig.UseTLS = redis.UseTLS
+	}
+	if redis.MaxActiveConnections != nil {
+		redisConfig.MaxActiveConnections = *redis.MaxActiveConnections
+	}
+	if redis.MaxIdleConnections != nil {
+		redisConfig.MaxIdleConnections = *redis.MaxIdleConnections
+	}
+	if redis.MaxConnectionLifetime != nil {
+		redisConfig.MaxConnectionLifetime = redis.MaxConnectionLifetime.Duration
+	}
+	if redis.MaxIdleTimeout != nil {
+		redisConfig.MaxIdleTimeout = redis.MaxIdleTimeout.Duration
+	}
+}
+
 // defaultBuxConfig is the default configuration
-func defaultBuxConfig() *config.AppConfig {
+func defaultBuxConfig(names buxNames) *config.AppConfig {
 	return &config.AppConfig{
 		Debug:          true,
 		DebugProfiling: false,
@@ -88,7 +121,7 @@ func defaultBuxConfig() *config.AppConfig {
 			Engine: cachestore.Redis,
 		},
 		Datastore: &config.DatastoreConfig{
-			AutoMigrate: true,
+			AutoMigrate: false,
 			Engine:      datastore.PostgreSQL,
 			Debug:       true,
 			TablePrefix: "bux",
@@ -118,7 +151,7 @@ func defaultBuxConfig() *config.AppConfig {
 			MaxConnectionLifetime: time.Second * 10,
 			MaxIdleConnections:    10,
 			MaxIdleTimeout:        time.Second * 10,
-			URL:                   "redis://redis-standalone:6379",
+			URL:                   fmt.Sprintf("redis://%s:6379", names.redis()),
 			UseTLS:                false,
 		},
 		Server: &config.ServerConfig{
@@ -128,7 +161,7 @@ func defaultBuxConfig() *config.AppConfig {
 			WriteTimeout: 15 * time.Second,
 		},
 		SQL: &datastore.SQLConfig{
-			Host:                      "bux-datastore",
+			Host:                      names.datastore(),
 			Name:                      "bux",
 			Password:                  "postgres",
 			Port:                      "5432",
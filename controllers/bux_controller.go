@@ -18,15 +18,25 @@ package controllers
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -37,17 +47,53 @@ import (
 // BuxReconciler reconciles a Bux object
 type BuxReconciler struct {
 	client.Client
+	// APIReader reads the objects the manager doesn't cache, like pods
+	APIReader client.Reader
+	Scheme    *runtime.Scheme
+	// RedisOperator is set when the redis operator is installed, otherwise
+	// the controller runs redis itself
+	RedisOperator bool
+	// CertManager is set when cert-manager is installed, the certificates of
+	// the ingresses are then part of the readiness of a Bux
+	CertManager bool
+	// MaxConcurrentReconciles is the number of Buxes reconciled at the same
+	// time, defaults to 1
+	MaxConcurrentReconciles int
+}
+
+// BuxRequest is the reconcile of one Bux, the reconcile steps are its methods
+// so that the BuxReconciler only holds what all the reconciles share
+type BuxRequest struct {
+	*BuxReconciler
 	Log            logr.Logger
-	Scheme         *runtime.Scheme
 	Context        context.Context
 	NamespacedName types.NamespacedName
+	// Bux is fetched once per reconcile, with the defaults of the BuxPlatform
+	// layered beneath its spec. The steps share it and must not change it.
+	Bux *serverv1alpha1.Bux
+	// BuxStatus is the status of the Bux being reconciled, written back once
+	// all the steps have run
+	BuxStatus *serverv1alpha1.BuxStatus
+	// RequeueAfter is set by the steps waiting on the cluster
+	RequeueAfter time.Duration
+	// Names are the names of the objects of the Bux being reconciled
+	Names buxNames
+	// Platform are the defaults of the BuxPlatform
+	Platform *serverv1alpha1.BuxPlatformSpec
+	// statusLock guards the conditions and RequeueAfter, the steps run concurrently
+	statusLock sync.Mutex
 }
 
 // +kubebuilder:rbac:groups=server.getbux.io,resources=buxes,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;patch
+// +kubebuilder:rbac:groups=batch,resources=cronjobs;jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses;networkpolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=redis.redis.opstreelabs.in,resources=redis,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups="",resources=services;configmaps;persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;configmaps;persistentvolumeclaims;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=server.getbux.io,resources=buxes/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=server.getbux.io,resources=buxes/finalizers,verbs=update
 
@@ -57,34 +103,51 @@ type BuxReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
 func (r *BuxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	r.Log = log.FromContext(ctx)
-	logger := r.Log.WithValues("bux", req.NamespacedName)
-	result := ctrl.Result{}
-	r.Context = ctx
-	r.NamespacedName = req.NamespacedName
+	logger := log.FromContext(ctx)
 	bux := serverv1alpha1.Bux{}
-
 	if err := r.Get(ctx, req.NamespacedName, &bux); err != nil {
-		logger.Error(err, "unable to fetch Bux CR")
-		return result, nil
+		logger.WithValues("bux", req.NamespacedName).Error(err, "unable to fetch Bux CR")
+		return ctrl.Result{}, nil
 	}
+	request := &BuxRequest{
+		BuxReconciler:  r,
+		Log:            logger,
+		Context:        ctx,
+		NamespacedName: req.NamespacedName,
+		BuxStatus:      &bux.Status,
+	}
+	return request.reconcile(&bux)
+}
 
-	_, err := ReconcileBatch(r.Log,
-		r.Validate,
-		r.ReconcileConfig,
-		r.ReconcileConsole,
-		r.ReconcileDatastore,
-		r.ReconcileRedis,
-		r.ReconcileService,
-		r.ReconcileIngress,
-		r.ReconcileDeployment,
-		r.ReconcileConsoleService,
-		r.ReconcileConsoleIngress,
-		r.ReconcileConsoleDeployment,
-	)
-
+// reconcile runs the steps for bux and writes back its status
+func (r *BuxRequest) reconcile(bux *serverv1alpha1.Bux) (ctrl.Result, error) {
+	result := ctrl.Result{}
+	if err := r.resolveNaming(bux); err != nil {
+		return result, err
+	}
+	r.Names = newBuxNames(bux)
+	platform, err := r.getPlatform()
 	if err != nil {
-		apimeta.SetStatusCondition(&bux.Status.Conditions,
+		return result, err
+	}
+	r.Platform = platform
+	r.Bux = bux.DeepCopy()
+	applyPlatformDefaults(&r.Bux.Spec, r.Platform)
+
+	steps := r.steps()
+	if bux.Spec.Paused {
+		// Only look, the objects of the Bux may be edited by hand
+		steps = steps[:1]
+	}
+	err = r.runSteps(steps)
+	readyErr := r.setReadyCondition()
+	if err == nil {
+		err = readyErr
+	}
+
+	switch {
+	case err != nil:
+		r.setCondition(
 			metav1.Condition{
 				Type:    serverv1alpha1.ConditionReconciled,
 				Status:  metav1.ConditionFalse,
@@ -92,8 +155,17 @@ func (r *BuxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 				Message: err.Error(),
 			},
 		)
-	} else {
-		apimeta.SetStatusCondition(&bux.Status.Conditions,
+	case bux.Spec.Paused:
+		r.setCondition(
+			metav1.Condition{
+				Type:    serverv1alpha1.ConditionReconciled,
+				Status:  metav1.ConditionFalse,
+				Reason:  serverv1alpha1.ReconciledReasonPaused,
+				Message: serverv1alpha1.ReconcilePausedMessage,
+			},
+		)
+	default:
+		r.setCondition(
 			metav1.Condition{
 				Type:    serverv1alpha1.ConditionReconciled,
 				Status:  metav1.ConditionTrue,
@@ -103,28 +175,81 @@ func (r *BuxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		)
 	}
 
-	statusErr := r.Client.Status().Update(ctx, &bux)
+	statusErr := r.Client.Status().Update(r.Context, bux)
 	if err == nil {
 		err = statusErr
 	}
 
-	return ctrl.Result{Requeue: false, RequeueAfter: 0}, err
+	return ctrl.Result{Requeue: false, RequeueAfter: r.RequeueAfter}, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *BuxReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&serverv1alpha1.Bux{}).
-		Owns(&appsv1.Deployment{}).
-		Owns(&corev1.Service{}).
-		Owns(&corev1.ConfigMap{}).
-		WithEventFilter(buxPredicate(r.Scheme)).
+	// Readiness comes from the status of the workloads, which doesn't change
+	// their generation
+	specChanged := builder.WithPredicates(buxPredicate(r.Scheme))
+	readinessChanged := builder.WithPredicates(workloadPredicate(r.Scheme))
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&serverv1alpha1.Bux{}, specChanged).
+		Owns(&appsv1.Deployment{}, readinessChanged).
+		Owns(&appsv1.StatefulSet{}, readinessChanged).
+		Owns(&batchv1.CronJob{}, specChanged).
+		Owns(&batchv1.Job{}, specChanged).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}, specChanged).
+		Owns(&policyv1.PodDisruptionBudget{}, specChanged).
+		Owns(&networkingv1.NetworkPolicy{}, specChanged).
+		Owns(&corev1.Service{}, specChanged).
+		Owns(&corev1.ConfigMap{}, specChanged).
+		Owns(&corev1.ServiceAccount{}, specChanged).
+		Watches(&source.Kind{Type: &serverv1alpha1.BuxPlatform{}},
+			handler.EnqueueRequestsFromMapFunc(r.platformRequests), specChanged)
+	if r.CertManager {
+		b = b.Watches(&source.Kind{Type: newCertificate()},
+			handler.EnqueueRequestsFromMapFunc(r.certificateRequests),
+			builder.WithPredicates(readinessChangedPredicate()))
+	}
+	return b.WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
 
-func (r *BuxReconciler) getAppLabels() map[string]string {
-	return map[string]string{
-		serverv1alpha1.BuxLabel: "true",
+func (r *BuxRequest) getAppLabels(c component) map[string]string {
+	labels := r.Names.labels(c)
+	labels[serverv1alpha1.BuxLabel] = "true"
+	return labels
+}
+
+// setCondition records a condition on the Bux being reconciled
+func (r *BuxRequest) setCondition(condition metav1.Condition) {
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+	apimeta.SetStatusCondition(&r.BuxStatus.Conditions, condition)
+}
+
+// condition returns a copy of the condition of the Bux being reconciled, or nil
+func (r *BuxRequest) condition(conditionType string) *metav1.Condition {
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+	condition := apimeta.FindStatusCondition(r.BuxStatus.Conditions, conditionType)
+	if condition == nil {
+		return nil
+	}
+	c := *condition
+	return &c
+}
+
+// removeCondition removes a condition from the Bux being reconciled
+func (r *BuxRequest) removeCondition(conditionType string) {
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+	apimeta.RemoveStatusCondition(&r.BuxStatus.Conditions, conditionType)
+}
+
+// requeueAfter asks for the Bux to be reconciled again, the shortest delay wins
+func (r *BuxRequest) requeueAfter(d time.Duration) {
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+	if r.RequeueAfter == 0 || d < r.RequeueAfter {
+		r.RequeueAfter = d
 	}
 }
 
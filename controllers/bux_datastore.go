@@ -5,40 +5,48 @@ import (
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// postgresqlImage is the postgresql server, it also provides pg_dump and pg_restore
+const postgresqlImage = "docker.io/galtbv/postgresql-12"
+
 // ReconcileDatastore is the datastore
-func (r *BuxReconciler) ReconcileDatastore(log logr.Logger) (bool, error) {
+func (r *BuxRequest) ReconcileDatastore(log logr.Logger) (bool, error) {
 	return ReconcileBatch(log,
-		r.ReconcilePostgresqlDeployment,
 		r.ReconcilePostgresqlPVC,
+		r.ReconcilePostgresqlStatefulSet,
 		r.ReconcileDatastoreService,
 	)
 }
 
-func (r *BuxReconciler) ReconcileDatastoreDeployment(log logr.Logger) (bool, error) {
-	return r.ReconcilePostgresqlDeployment(log)
-}
-
-// ReconcilePostgresqlDeployment is the postgres deployment
-func (r *BuxReconciler) ReconcilePostgresqlDeployment(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
+// ReconcilePostgresqlStatefulSet is the postgres statefulset
+func (r *BuxRequest) ReconcilePostgresqlStatefulSet(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	// Wait for the legacy deployment to be gone, its deletion requeues us
+	if removed, err := r.removeLegacyPostgresqlDeployment(bux); !removed || err != nil {
 		return false, err
 	}
-	dep := appsv1.Deployment{
+	sts := appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-postgresql",
+			Name:      r.Names.postgresql(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentDatastore),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &dep, func() error {
-		return r.updatePostgresqlDeployment(&dep, &bux)
+	// Selectors are immutable, wait for the statefulset with the old one to be replaced
+	old := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: sts.Name, Namespace: sts.Namespace}}
+	if migrated, err := r.migrateSelector(bux, old, r.Names.selector(componentDatastore)); !migrated || err != nil {
+		return false, err
+	}
+	err := r.apply(&sts, func() error {
+		return r.updatePostgresqlStatefulSet(&sts, bux)
 	})
 	if err != nil {
 		return false, err
@@ -46,66 +54,62 @@ func (r *BuxReconciler) ReconcilePostgresqlDeployment(_ logr.Logger) (bool, erro
 	return true, nil
 }
 
-// ReconcilePostgresqlPVC is the postgres PVC
-func (r *BuxReconciler) ReconcilePostgresqlPVC(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
+// removeLegacyPostgresqlDeployment deletes the postgres deployment created by
+// older versions of the controller so that the statefulset can take over the
+// bux-postgresql volume without two pods mounting it at the same time.
+// It returns true once the deployment and its pods are gone.
+func (r *BuxRequest) removeLegacyPostgresqlDeployment(bux *serverv1alpha1.Bux) (bool, error) {
+	dep := appsv1.Deployment{}
+	key := types.NamespacedName{Name: r.Names.postgresql(), Namespace: r.NamespacedName.Namespace}
+	if err := r.Get(r.Context, key, &dep); err != nil {
+		return k8serrors.IsNotFound(err), client.IgnoreNotFound(err)
 	}
-	pvc := corev1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-postgresql",
-			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
-		},
+	if !metav1.IsControlledBy(&dep, bux) {
+		return true, nil
 	}
-
-	_, _ = controllerutil.CreateOrUpdate(r.Context, r.Client, &pvc, func() error {
-		return r.updatePVC(&pvc, &bux)
-	})
-	// for now ignore errors since there are immutable fields
-	/*if err != nil && !k8serrors.IsForbidden(err) {
-		return false, err
-	}*/
-	return true, nil
+	if dep.DeletionTimestamp != nil {
+		return false, nil
+	}
+	err := r.Delete(r.Context, &dep, client.PropagationPolicy(metav1.DeletePropagationForeground))
+	return false, client.IgnoreNotFound(err)
 }
 
-func (r *BuxReconciler) updatePostgresqlDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux) error {
-	err := controllerutil.SetControllerReference(bux, dep, r.Scheme)
-	if err != nil {
-		return err
+// ReconcilePostgresqlPVC is the postgres PVC
+func (r *BuxRequest) ReconcilePostgresqlPVC(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	var storage *serverv1alpha1.StorageConfig
+	if bux.Spec.Postgresql != nil {
+		storage = bux.Spec.Postgresql.Storage
 	}
-	dep.Spec = *defaultPostgresqlDeploymentSpec()
-	return nil
+	return r.reconcilePVC(bux, r.Names.postgresql(), componentDatastore, serverv1alpha1.ConditionPostgresqlStorageReady,
+		defaultPVCSpec(storage, "2Gi"))
 }
 
-func (r *BuxReconciler) updatePVC(pvc *corev1.PersistentVolumeClaim, bux *serverv1alpha1.Bux) error {
-	err := controllerutil.SetControllerReference(bux, pvc, r.Scheme)
+func (r *BuxRequest) updatePostgresqlStatefulSet(sts *appsv1.StatefulSet, bux *serverv1alpha1.Bux) error {
+	err := controllerutil.SetControllerReference(bux, sts, r.Scheme)
 	if err != nil {
 		return err
 	}
-	pvc.Spec = *defaultPVCSpec()
-	return nil
+	sts.Spec = *defaultPostgresqlStatefulSetSpec(r.Names)
+	useRegistry(&sts.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return applyPodConfig(&sts.Spec.Template, postgresqlPodConfig(bux))
 }
 
-func defaultPVCSpec() *corev1.PersistentVolumeClaimSpec {
-	return &corev1.PersistentVolumeClaimSpec{
-		AccessModes: []corev1.PersistentVolumeAccessMode{
-			corev1.ReadWriteOnce,
+// defaultPostgresqlResources is used when the Bux does not set postgresql resources
+func defaultPostgresqlResources() *corev1.ResourceRequirements {
+	return &corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			"memory": resource.MustParse("1Gi"),
 		},
-		Resources: corev1.ResourceRequirements{
-			Requests: corev1.ResourceList{
-				"storage": resource.MustParse("2Gi"),
-			},
+		Requests: corev1.ResourceList{
+			"cpu":    resource.MustParse("100m"),
+			"memory": resource.MustParse("256Mi"),
 		},
 	}
 }
 
-func defaultPostgresqlDeploymentSpec() *appsv1.DeploymentSpec {
-	podLabels := map[string]string{
-		"app":        "bux",
-		"deployment": "bux-postgresql",
-	}
+func defaultPostgresqlStatefulSetSpec(names buxNames) *appsv1.StatefulSetSpec {
+	podLabels := names.labels(componentDatastore)
 	var envFrom []corev1.EnvFromSource
 	envVars := []corev1.EnvVar{
 		{
@@ -121,47 +125,63 @@ func defaultPostgresqlDeploymentSpec() *appsv1.DeploymentSpec {
 			Value: "bux",
 		},
 	}
-	image := "docker.io/galtbv/postgresql-12"
-	return &appsv1.DeploymentSpec{
-		Replicas: pointer.Int32Ptr(1),
-		Selector: metav1.SetAsLabelSelector(podLabels),
+	// pg_isready only checks that the server accepts connections, which is
+	// what the datastore service needs
+	isReady := corev1.ProbeHandler{
+		Exec: &corev1.ExecAction{
+			Command: []string{
+				"pg_isready",
+				"-h", "127.0.0.1",
+				"-p", "5432",
+				"-U", "bux",
+				"-d", "bux",
+			},
+		},
+	}
+	image := postgresqlImage
+	return &appsv1.StatefulSetSpec{
+		Replicas:    pointer.Int32Ptr(1),
+		ServiceName: names.datastore(),
+		Selector:    metav1.SetAsLabelSelector(names.selector(componentDatastore)),
 		Template: corev1.PodTemplateSpec{
 			ObjectMeta: metav1.ObjectMeta{
 				CreationTimestamp: metav1.Time{},
 				Labels:            podLabels,
 			},
 			Spec: corev1.PodSpec{
-				InitContainers: []corev1.Container{
-					{
-						Name:  "pgsql-data-permission-fix",
-						Image: "busybox",
-						Command: []string{
-							"/bin/chmod",
-							"-R",
-							"777",
-							"/data",
-						},
-						VolumeMounts: []corev1.VolumeMount{
-							{
-								Name:      "psql-data",
-								MountPath: "/data",
-							},
-						},
-					},
-				},
+				// the kubelet hands the data volume to the postgres group
+				// instead of chmod-ing it as root
+				SecurityContext:              restrictedPodSecurityContext(postgresqlUID),
+				ServiceAccountName:           names.postgresql(),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
 				Containers: []corev1.Container{
 					{
 						EnvFrom:                  envFrom,
 						Env:                      envVars,
 						Image:                    image,
 						Name:                     "postgresql",
+						Resources:                *defaultPostgresqlResources(),
 						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
 						Ports: []corev1.ContainerPort{
 							{
 								ContainerPort: 5432,
 								Protocol:      corev1.ProtocolTCP,
 							},
 						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:        isReady,
+							InitialDelaySeconds: 5,
+							PeriodSeconds:       10,
+							TimeoutSeconds:      5,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:        isReady,
+							InitialDelaySeconds: 30,
+							PeriodSeconds:       10,
+							TimeoutSeconds:      5,
+							FailureThreshold:    6,
+						},
 						VolumeMounts: []corev1.VolumeMount{
 							{
 								MountPath: "/var/lib/pgsql/data",
@@ -175,7 +195,7 @@ func defaultPostgresqlDeploymentSpec() *appsv1.DeploymentSpec {
 						Name: "psql-data",
 						VolumeSource: corev1.VolumeSource{
 							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-								ClaimName: "bux-postgresql",
+								ClaimName: names.postgresql(),
 							},
 						},
 					},
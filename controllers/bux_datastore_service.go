@@ -10,20 +10,17 @@ import (
 )
 
 // ReconcileDatastoreService is the datastore service
-func (r *BuxReconciler) ReconcileDatastoreService(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileDatastoreService(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	svc := corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-datastore",
+			Name:      r.Names.datastore(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentDatastore),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &svc, func() error {
-		return r.updateDatastoreService(&svc, &bux)
+	err := r.apply(&svc, func() error {
+		return r.updateDatastoreService(&svc, bux)
 	})
 	if err != nil {
 		return false, err
@@ -31,20 +28,17 @@ func (r *BuxReconciler) ReconcileDatastoreService(_ logr.Logger) (bool, error) {
 	return true, nil
 }
 
-func (r *BuxReconciler) updateDatastoreService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateDatastoreService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, svc, r.Scheme)
 	if err != nil {
 		return err
 	}
-	svc.Spec = *defaultDatastoreServiceSpec()
+	svc.Spec = *defaultDatastoreServiceSpec(r.Names)
 	return nil
 }
 
-func defaultDatastoreServiceSpec() *corev1.ServiceSpec {
-	labels := map[string]string{
-		"app":        "bux",
-		"deployment": "bux-postgresql",
-	}
+func defaultDatastoreServiceSpec(names buxNames) *corev1.ServiceSpec {
+	labels := names.selector(componentDatastore)
 	return &corev1.ServiceSpec{
 		Selector: labels,
 		Type:     corev1.ServiceTypeClusterIP,
@@ -5,26 +5,31 @@ import (
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // ReconcileDeployment is the deployment
-func (r *BuxReconciler) ReconcileDeployment(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileDeployment(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	dep := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux",
+			Name:      r.Names.server(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentServer),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &dep, func() error {
-		return r.updateDeployment(&dep, &bux)
+	// Selectors are immutable, wait for the deployment with the old one to be replaced
+	old := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: dep.Name, Namespace: dep.Namespace}}
+	if migrated, err := r.migrateSelector(bux, old, r.Names.selector(componentServer)); !migrated || err != nil {
+		return false, err
+	}
+	// old is the current deployment, if there is one
+	err := r.apply(&dep, func() error {
+		return r.updateDeployment(&dep, bux, old.Spec.Replicas)
 	})
 	if err != nil {
 		return false, err
@@ -32,20 +37,63 @@ func (r *BuxReconciler) ReconcileDeployment(_ logr.Logger) (bool, error) {
 	return true, nil
 }
 
-func (r *BuxReconciler) updateDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux) error {
+// updateDeployment renders the bux deployment, replicas are those of the
+// current deployment
+func (r *BuxRequest) updateDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux, replicas *int32) error {
 	err := controllerutil.SetControllerReference(bux, dep, r.Scheme)
 	if err != nil {
 		return err
 	}
-	dep.Spec = *defaultDeploymentSpec()
-	return nil
+	dep.Spec = *defaultDeploymentSpec(r.Names, desiredServerVersion(r.BuxStatus), bux.Spec.Replicas)
+	server := &dep.Spec.Template.Spec.Containers[0]
+	server.Env = append(server.Env, redisEnvVars(bux)...)
+	if bux.Spec.Autoscaling != nil {
+		// The autoscaler owns the replicas, unless we scaled down for
+		// maintenance, since it leaves a deployment at zero alone
+		dep.Spec.Replicas = nil
+		if replicas != nil && *replicas == 0 {
+			dep.Spec.Replicas = defaultAutoscalerSpec(r.Names, bux.Spec.Autoscaling).MinReplicas
+		}
+	}
+	if liteProfile(bux) {
+		// The in-memory state of a pod can't be shared, not even with the
+		// pod that replaces it during a rollout
+		dep.Spec.Replicas = pointer.Int32Ptr(1)
+		dep.Spec.Strategy = appsv1.DeploymentStrategy{
+			Type: appsv1.RecreateDeploymentStrategyType,
+		}
+	}
+	if maintenance(bux) {
+		dep.Spec.Replicas = pointer.Int32Ptr(0)
+	}
+	useRegistry(&dep.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return applyPodConfig(&dep.Spec.Template, serverPodConfig(bux))
+}
+
+// buxImage is the bux-server image of version
+func buxImage(version string) string {
+	return "docker.io/galtbv/bux:" + version
+}
+
+// buxImagePullPolicy only pulls pinned versions once, latest is a moving tag
+func buxImagePullPolicy(version string) corev1.PullPolicy {
+	if version == latestVersion {
+		return corev1.PullAlways
+	}
+	return corev1.PullIfNotPresent
 }
 
-func defaultDeploymentSpec() *appsv1.DeploymentSpec {
-	podLabels := map[string]string{
-		"app":        "bux",
-		"deployment": "bux",
+func defaultDeploymentSpec(names buxNames, version string, replicas *int32) *appsv1.DeploymentSpec {
+	if replicas == nil {
+		replicas = pointer.Int32Ptr(1)
 	}
+	health := corev1.ProbeHandler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path: "/health",
+			Port: intstr.FromInt(3003),
+		},
+	}
+	podLabels := names.labels(componentServer)
 	var envFrom []corev1.EnvFromSource
 	envVars := []corev1.EnvVar{
 		{
@@ -53,24 +101,55 @@ func defaultDeploymentSpec() *appsv1.DeploymentSpec {
 			Value: "development",
 		},
 	}
-	image := "docker.io/galtbv/bux:latest"
 	return &appsv1.DeploymentSpec{
-		Replicas: pointer.Int32Ptr(1),
-		Selector: metav1.SetAsLabelSelector(podLabels),
+		Replicas: replicas,
+		Selector: metav1.SetAsLabelSelector(names.selector(componentServer)),
 		Template: corev1.PodTemplateSpec{
 			ObjectMeta: metav1.ObjectMeta{
 				CreationTimestamp: metav1.Time{},
 				Labels:            podLabels,
 			},
 			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(defaultUID),
+				ServiceAccountName:           names.server(),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
 				Containers: []corev1.Container{
 					{
 						EnvFrom:                  envFrom,
 						Env:                      envVars,
-						Image:                    image,
-						ImagePullPolicy:          corev1.PullAlways,
+						Image:                    buxImage(version),
+						ImagePullPolicy:          buxImagePullPolicy(version),
 						Name:                     "bux",
 						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						// The autoscaler measures utilization against the requests
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("512Mi"),
+							},
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("100m"),
+								corev1.ResourceMemory: resource.MustParse("128Mi"),
+							},
+						},
+						// bux-server migrates the datastore before it serves /health
+						StartupProbe: &corev1.Probe{
+							ProbeHandler:     health,
+							PeriodSeconds:    5,
+							FailureThreshold: 60,
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:     health,
+							PeriodSeconds:    10,
+							TimeoutSeconds:   5,
+							FailureThreshold: 3,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:     health,
+							PeriodSeconds:    10,
+							TimeoutSeconds:   5,
+							FailureThreshold: 6,
+						},
 						Ports: []corev1.ContainerPort{
 							{
 								ContainerPort: 3003,
@@ -99,7 +178,7 @@ func defaultDeploymentSpec() *appsv1.DeploymentSpec {
 						VolumeSource: corev1.VolumeSource{
 							ConfigMap: &corev1.ConfigMapVolumeSource{
 								LocalObjectReference: corev1.LocalObjectReference{
-									Name: "bux-config",
+									Name: names.config(),
 								},
 							},
 						},
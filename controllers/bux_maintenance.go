@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"fmt"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// maintenanceImage serves the maintenance response
+	maintenanceImage = "docker.io/nginxinc/nginx-unprivileged:1.23-alpine"
+
+	// maintenanceUID is the nginx user of the unprivileged nginx image
+	maintenanceUID = 101
+
+	// maintenancePort is where nginx listens, the unprivileged image can't bind 80
+	maintenancePort = 8080
+
+	// defaultMaintenanceContentType is the content type of a maintenance
+	// response that doesn't set one
+	defaultMaintenanceContentType = "application/json"
+
+	// maintenanceChecksumAnnotation rolls the maintenance pods when the
+	// response changes, nginx only reads its config on start
+	maintenanceChecksumAnnotation = "getbux.io/maintenance-checksum"
+)
+
+// maintenance returns true if bux-server is scaled down for maintenance
+func maintenance(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Maintenance != nil && bux.Spec.Maintenance.Enabled
+}
+
+// maintenanceResponse is the response served instead of bux-server, nil when
+// there is none
+func maintenanceResponse(bux *serverv1alpha1.Bux) *serverv1alpha1.MaintenanceResponse {
+	if !maintenance(bux) {
+		return nil
+	}
+	return bux.Spec.Maintenance.Response
+}
+
+// ReconcileMaintenance serves the maintenance response while bux-server is down,
+// and removes it again afterwards
+func (r *BuxRequest) ReconcileMaintenance(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	r.setMaintenanceCondition(bux)
+	response := maintenanceResponse(bux)
+	if response == nil {
+		return true, r.removeMaintenance(bux)
+	}
+
+	cm := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.maintenance(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentMaintenance),
+		},
+	}
+	err := r.apply(&cm, func() error {
+		if err := controllerutil.SetControllerReference(bux, &cm, r.Scheme); err != nil {
+			return err
+		}
+		cm.Data = maintenanceConfigData(response)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	svc := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.maintenance(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentMaintenance),
+		},
+	}
+	err = r.apply(&svc, func() error {
+		if err := controllerutil.SetControllerReference(bux, &svc, r.Scheme); err != nil {
+			return err
+		}
+		svc.Spec = *defaultMaintenanceServiceSpec(r.Names)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	dep := appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.maintenance(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentMaintenance),
+		},
+	}
+	err = r.apply(&dep, func() error {
+		return r.updateMaintenanceDeployment(&dep, bux, cm.Data)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) updateMaintenanceDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux,
+	data map[string]string,
+) error {
+	err := controllerutil.SetControllerReference(bux, dep, r.Scheme)
+	if err != nil {
+		return err
+	}
+	dep.Spec = *defaultMaintenanceDeploymentSpec(r.Names)
+	dep.Spec.Template.Annotations = map[string]string{
+		maintenanceChecksumAnnotation: shortHash(data["default.conf"] + data["maintenance"]),
+	}
+	useRegistry(&dep.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return nil
+}
+
+// removeMaintenance deletes the maintenance response objects we own
+func (r *BuxRequest) removeMaintenance(bux *serverv1alpha1.Bux) error {
+	return r.removeObjects(bux, r.Names.maintenance(), &appsv1.Deployment{}, &corev1.Service{}, &corev1.ConfigMap{})
+}
+
+func (r *BuxRequest) setMaintenanceCondition(bux *serverv1alpha1.Bux) {
+	condition := metav1.Condition{
+		Type:    serverv1alpha1.ConditionMaintenance,
+		Status:  metav1.ConditionFalse,
+		Reason:  serverv1alpha1.MaintenanceReasonDisabled,
+		Message: "bux-server is serving",
+	}
+	if maintenance(bux) {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = serverv1alpha1.MaintenanceReasonEnabled
+		condition.Message = "bux-server is scaled down for maintenance"
+	}
+	r.setCondition(condition)
+}
+
+// maintenanceConfigData is the nginx config that answers every request with
+// the response and a 503
+func maintenanceConfigData(response *serverv1alpha1.MaintenanceResponse) map[string]string {
+	contentType := response.ContentType
+	if contentType == "" {
+		contentType = defaultMaintenanceContentType
+	}
+	conf := fmt.Sprintf(`server {
+    listen %d;
+    root /usr/share/nginx/maintenance;
+    error_page 503 /maintenance;
+    location / {
+        return 503;
+    }
+    location = /maintenance {
+        internal;
+        default_type "%s";
+    }
+}
+`, maintenancePort, contentType)
+	return map[string]string{
+		"default.conf": conf,
+		"maintenance":  response.Body,
+	}
+}
+
+func defaultMaintenanceServiceSpec(names buxNames) *corev1.ServiceSpec {
+	return &corev1.ServiceSpec{
+		Selector: names.selector(componentMaintenance),
+		Type:     corev1.ServiceTypeClusterIP,
+		Ports: []corev1.ServicePort{
+			{
+				Name:       "http",
+				Port:       int32(maintenancePort),
+				TargetPort: intstr.FromInt(maintenancePort),
+			},
+		},
+	}
+}
+
+func defaultMaintenanceDeploymentSpec(names buxNames) *appsv1.DeploymentSpec {
+	listening := corev1.ProbeHandler{
+		TCPSocket: &corev1.TCPSocketAction{
+			Port: intstr.FromInt(maintenancePort),
+		},
+	}
+	return &appsv1.DeploymentSpec{
+		Replicas: pointer.Int32Ptr(1),
+		Selector: metav1.SetAsLabelSelector(names.selector(componentMaintenance)),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.Time{},
+				Labels:            names.labels(componentMaintenance),
+			},
+			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(maintenanceUID),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
+				Containers: []corev1.Container{
+					{
+						Image:                    maintenanceImage,
+						ImagePullPolicy:          corev1.PullIfNotPresent,
+						Name:                     "maintenance",
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("32Mi"),
+							},
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("10m"),
+								corev1.ResourceMemory: resource.MustParse("16Mi"),
+							},
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:  listening,
+							PeriodSeconds: 10,
+						},
+						Ports: []corev1.ContainerPort{
+							{
+								ContainerPort: maintenancePort,
+								Protocol:      corev1.ProtocolTCP,
+							},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{
+								Name:      "config",
+								MountPath: "/etc/nginx/conf.d",
+								ReadOnly:  true,
+							},
+							{
+								Name:      "response",
+								MountPath: "/usr/share/nginx/maintenance",
+								ReadOnly:  true,
+							},
+						},
+					},
+				},
+				Volumes: []corev1.Volume{
+					{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: names.maintenance()},
+								Items:                []corev1.KeyToPath{{Key: "default.conf", Path: "default.conf"}},
+							},
+						},
+					},
+					{
+						Name: "response",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: names.maintenance()},
+								Items:                []corev1.KeyToPath{{Key: "maintenance", Path: "maintenance"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
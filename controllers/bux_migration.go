@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// migrationJobLabel marks the migration jobs of a Bux
+	migrationJobLabel = "getbux.io/migration"
+
+	// migratedAnnotation marks a migration job whose bux-server served
+	// requests, it is suspended then as bux-server doesn't exit by itself
+	migratedAnnotation = "getbux.io/migrated"
+
+	// migrationRequeueInterval is how often we check on a running migration job
+	migrationRequeueInterval = 15 * time.Second
+)
+
+// ReconcileMigration runs the datastore migrations once for every bux-server
+// image, before the bux deployment is rolled to that image. A failed migration
+// holds the rollout so the running pods keep their schema.
+func (r *BuxRequest) ReconcileMigration(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	// The rolled back version has been migrated before, and its job may be
+	// gone, so don't run it over the schema of the failed version again
+	if upgrade := r.BuxStatus.Upgrade; upgrade != nil && upgrade.Phase == serverv1alpha1.UpgradePhaseRolledBack {
+		return true, nil
+	}
+	image := buxImage(desiredServerVersion(r.BuxStatus))
+	name := migrationJobName(r.Names, image)
+	if err := r.removeStaleMigrationJobs(bux, name); err != nil {
+		return false, err
+	}
+
+	job := batchv1.Job{}
+	key := types.NamespacedName{Name: name, Namespace: r.NamespacedName.Namespace}
+	err := r.Get(r.Context, key, &job)
+	if k8serrors.IsNotFound(err) {
+		labels := r.getAppLabels(componentMigration)
+		labels[migrationJobLabel] = "true"
+		job = batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: r.NamespacedName.Namespace,
+				Labels:    labels,
+			},
+			Spec: *defaultMigrationJobSpec(r.Names, image),
+		}
+		migrate := &job.Spec.Template.Spec.Containers[0]
+		migrate.Env = append(migrate.Env, redisEnvVars(bux)...)
+		useRegistry(&job.Spec.Template.Spec, bux.Spec.ImageRegistry)
+		if err = controllerutil.SetControllerReference(bux, &job, r.Scheme); err != nil {
+			return false, err
+		}
+		if err = r.Create(r.Context, &job); err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	// bux-server migrates the datastore before it serves /health, so the
+	// migration is done once its pod is ready
+	if !jobMigrated(&job) && !jobFailed(&job) {
+		ready, err := r.jobPodReady(&job)
+		if err != nil {
+			return false, err
+		}
+		if ready {
+			if err = r.suspendMigratedJob(&job); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	switch {
+	case jobMigrated(&job):
+		r.setMigrationCondition(metav1.ConditionTrue, serverv1alpha1.MigrationReasonSucceeded,
+			fmt.Sprintf("migrated for %s", image))
+		return true, nil
+	case jobFailed(&job):
+		r.setMigrationCondition(metav1.ConditionFalse, serverv1alpha1.MigrationReasonFailed,
+			fmt.Sprintf("migration job %s for %s failed, delete the job to retry", name, image))
+		return false, nil
+	default:
+		r.setMigrationCondition(metav1.ConditionFalse, serverv1alpha1.MigrationReasonRunning,
+			fmt.Sprintf("waiting for migration job %s for %s", name, image))
+		r.requeueAfter(migrationRequeueInterval)
+		return false, nil
+	}
+}
+
+// removeStaleMigrationJobs deletes the finished migration jobs of previous images
+func (r *BuxRequest) removeStaleMigrationJobs(bux *serverv1alpha1.Bux, current string) error {
+	jobs := batchv1.JobList{}
+	if err := r.List(r.Context, &jobs, client.InNamespace(r.NamespacedName.Namespace),
+		client.MatchingLabels{migrationJobLabel: "true"}); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Name == current || !metav1.IsControlledBy(job, bux) {
+			continue
+		}
+		if !jobMigrated(job) && !jobFailed(job) {
+			continue
+		}
+		err := r.Delete(r.Context, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobPodReady returns true if a pod of the job is ready
+func (r *BuxRequest) jobPodReady(job *batchv1.Job) (bool, error) {
+	pods := corev1.PodList{}
+	if err := r.APIReader.List(r.Context, &pods, client.InNamespace(job.Namespace),
+		client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return false, err
+	}
+	for i := range pods.Items {
+		if metav1.IsControlledBy(&pods.Items[i], job) && podReady(&pods.Items[i]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// suspendMigratedJob records that the job migrated and suspends it, which
+// stops its bux-server and keeps the job from starting another one
+func (r *BuxRequest) suspendMigratedJob(job *batchv1.Job) error {
+	patch := client.MergeFrom(job.DeepCopy())
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[migratedAnnotation] = "true"
+	job.Spec.Suspend = pointer.BoolPtr(true)
+	return r.Patch(r.Context, job, patch)
+}
+
+// jobMigrated returns true if the migration job completed its migration
+func jobMigrated(job *batchv1.Job) bool {
+	return job.Status.Succeeded > 0 || job.Annotations[migratedAnnotation] == "true"
+}
+
+// podReady returns true if the Ready condition of the pod is true
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *BuxRequest) setMigrationCondition(status metav1.ConditionStatus, reason, message string) {
+	r.setCondition(metav1.Condition{
+		Type:    serverv1alpha1.ConditionMigrated,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// migrationJobName is unique for every image so a new image gets a new job
+func migrationJobName(names buxNames, image string) string {
+	return names.migrate() + "-" + shortHash(image)
+}
+
+// shortHash is a name safe digest of s
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+func defaultMigrationJobSpec(names buxNames, image string) *batchv1.JobSpec {
+	podLabels := names.labels(componentMigration)
+	return &batchv1.JobSpec{
+		BackoffLimit:          pointer.Int32Ptr(2),
+		ActiveDeadlineSeconds: pointer.Int64Ptr(900),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.Time{},
+				Labels:            podLabels,
+			},
+			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(defaultUID),
+				ServiceAccountName:           names.server(),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
+				RestartPolicy:                corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:  "migrate",
+						Image: image,
+						Env: []corev1.EnvVar{
+							{
+								Name:  "BUX_ENVIRONMENT",
+								Value: "development",
+							},
+							{
+								Name:  "BUX_DATASTORE__AUTO_MIGRATE",
+								Value: "true",
+							},
+						},
+						// bux-server migrates the datastore before it serves /health
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								HTTPGet: &corev1.HTTPGetAction{
+									Path: "/health",
+									Port: intstr.FromInt(3003),
+								},
+							},
+							PeriodSeconds: 5,
+						},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						VolumeMounts: []corev1.VolumeMount{
+							{
+								MountPath: "config/envs",
+								Name:      "config",
+							},
+						},
+					},
+				},
+				Volumes: []corev1.Volume{
+					{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: names.config(),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestMigrationIsDoneOnceTheJobServes(t *testing.T) {
+	bux := &serverv1alpha1.Bux{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"}}
+	r := fakeRequest(t, bux)
+	image := buxImage(desiredServerVersion(r.BuxStatus))
+	key := types.NamespacedName{Name: migrationJobName(r.Names, image), Namespace: bux.Namespace}
+
+	if done, err := r.ReconcileMigration(r.Log); err != nil || done {
+		t.Fatalf("without a job: done = %t, %v", done, err)
+	}
+	job := batchv1.Job{}
+	if err := r.Get(r.Context, key, &job); err != nil {
+		t.Fatal(err)
+	}
+	migrate := job.Spec.Template.Spec.Containers[0]
+	if len(migrate.Command) > 0 {
+		t.Errorf("the job runs %v instead of bux-server", migrate.Command)
+	}
+	env := map[string]string{}
+	for _, v := range migrate.Env {
+		env[v.Name] = v.Value
+	}
+	if env["BUX_ENVIRONMENT"] != "development" || env["BUX_DATASTORE__AUTO_MIGRATE"] != "true" {
+		t.Errorf("the job doesn't auto migrate the development environment: %v", env)
+	}
+
+	// the pod of the job is running but doesn't serve yet
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      key.Name + "-x7k2p",
+		Namespace: bux.Namespace,
+		Labels:    map[string]string{"job-name": key.Name},
+	}}
+	if err := controllerutil.SetControllerReference(&job, pod, r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(r.Context, pod); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := r.ReconcileMigration(r.Log); err != nil || done {
+		t.Fatalf("while migrating: done = %t, %v", done, err)
+	}
+
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if err := r.Status().Update(r.Context, pod); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := r.ReconcileMigration(r.Log); err != nil || !done {
+		t.Fatalf("once serving: done = %t, %v", done, err)
+	}
+	if err := r.Get(r.Context, key, &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Spec.Suspend == nil || !*job.Spec.Suspend || !jobMigrated(&job) {
+		t.Errorf("the migrated job is not suspended: %v %v", job.Annotations, job.Spec.Suspend)
+	}
+	if !apimeta.IsStatusConditionTrue(r.BuxStatus.Conditions, serverv1alpha1.ConditionMigrated) {
+		t.Errorf("not migrated: %v", r.BuxStatus.Conditions)
+	}
+}
+
+func TestFailedMigrationHoldsTheRollout(t *testing.T) {
+	bux := &serverv1alpha1.Bux{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"}}
+	names := buxNames{instance: bux.Name}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      migrationJobName(names, buxImage(desiredServerVersion(&serverv1alpha1.BuxStatus{}))),
+			Namespace: bux.Namespace,
+		},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+		}},
+	}
+	r := fakeRequest(t, bux, job)
+	if done, err := r.ReconcileMigration(r.Log); err != nil || done {
+		t.Fatalf("done = %t, %v", done, err)
+	}
+	condition := apimeta.FindStatusCondition(r.BuxStatus.Conditions, serverv1alpha1.ConditionMigrated)
+	if condition == nil || condition.Reason != serverv1alpha1.MigrationReasonFailed {
+		t.Errorf("migrated condition is %v", condition)
+	}
+}
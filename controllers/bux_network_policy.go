@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// defaultIngressNamespace is where the nginx ingress controller is installed
+const defaultIngressNamespace = "ingress-nginx"
+
+// networkPolicy is a NetworkPolicy the controller manages
+type networkPolicy struct {
+	name      func(names buxNames) string
+	component component
+	spec      func(names buxNames, bux *serverv1alpha1.Bux) *networkingv1.NetworkPolicySpec
+	// when is whether the component runs, it always does when nil. The step
+	// that runs the component removes the policy when it doesn't.
+	when func(bux *serverv1alpha1.Bux) bool
+}
+
+// networkPolicies isolate the datastore, redis, the console mongodb and bux-server
+var networkPolicies = []networkPolicy{
+	{
+		name:      buxNames.datastore,
+		component: componentDatastore,
+		spec:      defaultDatastoreNetworkPolicySpec,
+	},
+	{
+		name:      func(n buxNames) string { return n.child("redis") },
+		component: componentCache,
+		spec:      defaultRedisNetworkPolicySpec,
+		when:      redisEnabled,
+	},
+	{
+		name:      buxNames.consoleMongo,
+		component: componentConsoleMongo,
+		spec:      defaultConsoleMongoNetworkPolicySpec,
+		when:      consoleEnabled,
+	},
+	{
+		name:      buxNames.server,
+		component: componentServer,
+		spec:      defaultServerNetworkPolicySpec,
+	},
+}
+
+// ReconcileNetworkPolicies are the network policies, they are removed again
+// when spec.networkPolicy is disabled
+func (r *BuxRequest) ReconcileNetworkPolicies(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	enabled := bux.Spec.NetworkPolicy != nil && bux.Spec.NetworkPolicy.Enabled
+	for _, policy := range networkPolicies {
+		if !enabled {
+			if err := r.removeNetworkPolicy(bux, policy.name(r.Names)); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if policy.when != nil && !policy.when(bux) {
+			continue
+		}
+		np := networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      policy.name(r.Names),
+				Namespace: r.NamespacedName.Namespace,
+				Labels:    r.getAppLabels(policy.component),
+			},
+		}
+		spec := policy.spec(r.Names, bux)
+		err := r.apply(&np, func() error {
+			return r.updateNetworkPolicy(&np, bux, spec)
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) updateNetworkPolicy(np *networkingv1.NetworkPolicy, bux *serverv1alpha1.Bux,
+	spec *networkingv1.NetworkPolicySpec,
+) error {
+	err := controllerutil.SetControllerReference(bux, np, r.Scheme)
+	if err != nil {
+		return err
+	}
+	np.Spec = *spec
+	return nil
+}
+
+// removeNetworkPolicy deletes the network policy, if we own it
+func (r *BuxRequest) removeNetworkPolicy(bux *serverv1alpha1.Bux, name string) error {
+	np := networkingv1.NetworkPolicy{}
+	key := types.NamespacedName{Name: name, Namespace: r.NamespacedName.Namespace}
+	if err := r.Get(r.Context, key, &np); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(&np, bux) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(r.Context, &np))
+}
+
+// fromComponents selects the pods of the components of the Bux
+func fromComponents(names buxNames, components ...component) []networkingv1.NetworkPolicyPeer {
+	values := make([]string, 0, len(components))
+	for _, c := range components {
+		values = append(values, c.component)
+	}
+	return []networkingv1.NetworkPolicyPeer{
+		{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					serverv1alpha1.InstanceLabel: names.instance,
+				},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      componentLabel,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   values,
+					},
+				},
+			},
+		},
+	}
+}
+
+// tcpPorts are the ports a policy lets through
+func tcpPorts(ports ...int) []networkingv1.NetworkPolicyPort {
+	protocol := corev1.ProtocolTCP
+	policyPorts := make([]networkingv1.NetworkPolicyPort, 0, len(ports))
+	for _, port := range ports {
+		port := intstr.FromInt(port)
+		policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{
+			Protocol: &protocol,
+			Port:     &port,
+		})
+	}
+	return policyPorts
+}
+
+// defaultDatastoreNetworkPolicySpec lets bux-server and the jobs that dump,
+// restore and migrate the datastore reach postgresql
+func defaultDatastoreNetworkPolicySpec(names buxNames, _ *serverv1alpha1.Bux) *networkingv1.NetworkPolicySpec {
+	return &networkingv1.NetworkPolicySpec{
+		PodSelector: *metav1.SetAsLabelSelector(names.selector(componentDatastore)),
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{
+				From:  fromComponents(names, componentServer, componentBackup, componentRestore, componentMigration),
+				Ports: tcpPorts(5432),
+			},
+		},
+	}
+}
+
+// defaultRedisNetworkPolicySpec lets bux-server and the migration job reach redis
+func defaultRedisNetworkPolicySpec(names buxNames, _ *serverv1alpha1.Bux) *networkingv1.NetworkPolicySpec {
+	return &networkingv1.NetworkPolicySpec{
+		PodSelector: *metav1.SetAsLabelSelector(map[string]string{
+			// set on its pods by the redis operator
+			"app": names.redis(),
+		}),
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{
+				From:  fromComponents(names, componentServer, componentMigration),
+				Ports: tcpPorts(6379),
+			},
+		},
+	}
+}
+
+// defaultConsoleMongoNetworkPolicySpec lets only bux-console reach its mongodb
+func defaultConsoleMongoNetworkPolicySpec(names buxNames, _ *serverv1alpha1.Bux) *networkingv1.NetworkPolicySpec {
+	return &networkingv1.NetworkPolicySpec{
+		PodSelector: *metav1.SetAsLabelSelector(names.selector(componentConsoleMongo)),
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{
+				From:  fromComponents(names, componentConsole),
+				Ports: tcpPorts(27017),
+			},
+		},
+	}
+}
+
+// defaultServerNetworkPolicySpec lets the ingress controller reach bux-server
+func defaultServerNetworkPolicySpec(names buxNames, bux *serverv1alpha1.Bux) *networkingv1.NetworkPolicySpec {
+	ingressNamespace := bux.Spec.NetworkPolicy.IngressNamespace
+	if ingressNamespace == "" {
+		ingressNamespace = defaultIngressNamespace
+	}
+	return &networkingv1.NetworkPolicySpec{
+		PodSelector: *metav1.SetAsLabelSelector(names.selector(componentServer)),
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: metav1.SetAsLabelSelector(map[string]string{
+							"kubernetes.io/metadata.name": ingressNamespace,
+						}),
+					},
+				},
+				Ports: tcpPorts(3003),
+			},
+		},
+	}
+}
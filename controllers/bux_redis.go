@@ -1,30 +1,48 @@
 package controllers
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
+
 	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
 	"github.com/go-logr/logr"
 	redisv1beta1 "github.com/murray-distributed-technologies/redis-operator/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // ReconcileRedis is for redis
-func (r *BuxReconciler) ReconcileRedis(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
+func (r *BuxRequest) ReconcileRedis(log logr.Logger) (bool, error) {
+	bux := r.Bux
+	if !r.RedisOperator {
+		return ReconcileBatch(log,
+			r.ReconcileRedisStatefulSet,
+			r.ReconcileRedisService,
+		)
 	}
 	redis := redisv1beta1.Redis{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "redis-standalone",
+			Name:      r.Names.redis(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentCache),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &redis, func() error {
-		return r.updateRedis(&redis, &bux)
+	// The operator selects the redis pods by the labels of the CR, so an
+	// existing CR keeps the labels it was created with
+	current := redisv1beta1.Redis{}
+	err := r.Get(r.Context, client.ObjectKeyFromObject(&redis), &current)
+	if err == nil {
+		redis.Labels = current.Labels
+	} else if !k8serrors.IsNotFound(err) {
+		return false, err
+	}
+	err = r.apply(&redis, func() error {
+		return r.updateRedis(&redis, bux)
 	})
 	if err != nil {
 		return false, err
@@ -32,12 +50,56 @@ func (r *BuxReconciler) ReconcileRedis(_ logr.Logger) (bool, error) {
 	return true, nil
 }
 
-func (r *BuxReconciler) updateRedis(redis *redisv1beta1.Redis, _ *serverv1alpha1.Bux) error {
-	redis.Spec = *defaultRedisSpec()
+func (r *BuxRequest) updateRedis(redis *redisv1beta1.Redis, bux *serverv1alpha1.Bux) error {
+	if err := controllerutil.SetControllerReference(bux, redis, r.Scheme); err != nil {
+		return err
+	}
+	var storage *serverv1alpha1.StorageConfig
+	if bux.Spec.Redis != nil {
+		storage = bux.Spec.Redis.Storage
+	}
+	redis.Spec = *defaultRedisSpec(storage)
+	redis.Spec.KubernetesConfig.Image = withRegistry(bux.Spec.ImageRegistry, redis.Spec.KubernetesConfig.Image)
+	redis.Spec.RedisExporter.Image = withRegistry(bux.Spec.ImageRegistry, redis.Spec.RedisExporter.Image)
 	return nil
 }
 
-func defaultRedisSpec() *redisv1beta1.RedisSpec {
+// externalRedis returns true if bux-server uses a redis outside of the cluster
+func externalRedis(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Redis != nil && bux.Spec.Redis.URL != ""
+}
+
+// redisEnvVars override the redis URL of the configuration with one that has
+// the password of the external redis, which kubelet expands from the secret
+func redisEnvVars(bux *serverv1alpha1.Bux) []corev1.EnvVar {
+	if !externalRedis(bux) || bux.Spec.Redis.PasswordSecret == nil {
+		return nil
+	}
+	redisURL, err := url.Parse(bux.Spec.Redis.URL)
+	if err != nil {
+		// Validate refuses the Bux before we get here
+		return nil
+	}
+	username := redisURL.User.Username()
+	redisURL.User = nil
+	withPassword := strings.Replace(redisURL.String(), "://",
+		fmt.Sprintf("://%s:$(REDIS_PASSWORD)@", url.PathEscape(username)), 1)
+	return []corev1.EnvVar{
+		{
+			Name: "REDIS_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: bux.Spec.Redis.PasswordSecret,
+			},
+		},
+		{
+			// REDIS_PASSWORD has to come first to be expanded here
+			Name:  "BUX_REDIS__URL",
+			Value: withPassword,
+		},
+	}
+}
+
+func defaultRedisSpec(storage *serverv1alpha1.StorageConfig) *redisv1beta1.RedisSpec {
 	return &redisv1beta1.RedisSpec{
 		KubernetesConfig: redisv1beta1.KubernetesConfig{
 			Image:           "quay.io/opstree/redis:v6.2.5",
@@ -53,22 +115,16 @@ func defaultRedisSpec() *redisv1beta1.RedisSpec {
 				},
 			},
 		},
+		// the operator doesn't set container security contexts, so only the
+		// pod level settings of the restricted profile can be applied
+		SecurityContext: restrictedPodSecurityContext(defaultUID),
 		RedisExporter: &redisv1beta1.RedisExporter{
 			Image:   "quay.io/opstree/redis-exporter:1.0",
 			Enabled: false,
 		},
 		Storage: &redisv1beta1.Storage{
 			VolumeClaimTemplate: corev1.PersistentVolumeClaim{
-				Spec: corev1.PersistentVolumeClaimSpec{
-					AccessModes: []corev1.PersistentVolumeAccessMode{
-						corev1.ReadWriteOnce,
-					},
-					Resources: corev1.ResourceRequirements{
-						Requests: corev1.ResourceList{
-							"storage": resource.MustParse("1Gi"),
-						},
-					},
-				},
+				Spec: *defaultPVCSpec(storage, "1Gi"),
 			},
 		},
 	}
@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// restoreRequeueInterval is how often we check on a running restore job
+const restoreRequeueInterval = 15 * time.Second
+
+// restoreDownloadScript downloads RESTORE_KEY, or the newest backup under the prefix
+const restoreDownloadScript = awsCliPrelude + `key="${RESTORE_KEY}"
+if [ -z "${key}" ]; then
+  key="$(aws "$@" s3api list-objects-v2 --bucket "${S3_BUCKET}" --prefix "${S3_PREFIX}/" \
+    --query 'sort_by(Contents, &Key)[].Key' --output text | tr '\t' '\n' | grep -e "-${BACKUP_FILE}$" | tail -n 1)"
+fi
+if [ -z "${key}" ]; then
+  echo "no backup found in s3://${S3_BUCKET}/${S3_PREFIX}/" >&2
+  exit 1
+fi
+echo "restoring s3://${S3_BUCKET}/${key}"
+aws "$@" s3 cp "s3://${S3_BUCKET}/${key}" "/backup/${BACKUP_FILE}"
+`
+
+// postgresqlRestoreScript waits for the datastore at PGHOST and restores the dump into it
+const postgresqlRestoreScript = `set -eu
+until pg_isready -p 5432 -U bux -d bux; do
+  sleep 2
+done
+pg_restore -p 5432 -U bux -d bux --clean --if-exists --no-owner "/backup/${BACKUP_FILE}"
+`
+
+// ReconcileRestore restores the datastore from spec.restoreFrom before
+// bux-server is deployed for the first time. It holds the remaining
+// steps after it, and so the server, until the restore succeeded.
+func (r *BuxRequest) ReconcileRestore(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	condition := r.condition(serverv1alpha1.ConditionRestored)
+	if condition != nil && (condition.Reason == serverv1alpha1.RestoreReasonSucceeded ||
+		condition.Reason == serverv1alpha1.RestoreReasonSkipped) {
+		return true, nil
+	}
+	if condition == nil {
+		// Never restore over a datastore bux-server has already been using
+		deployed, err := r.serverDeployed()
+		if err != nil {
+			return false, err
+		}
+		if deployed {
+			r.setRestoreCondition(metav1.ConditionFalse, serverv1alpha1.RestoreReasonSkipped,
+				"restoreFrom is only used when the Bux is created and bux-server was already deployed")
+			return true, nil
+		}
+	}
+
+	job := batchv1.Job{}
+	key := types.NamespacedName{Name: r.Names.restore(), Namespace: r.NamespacedName.Namespace}
+	err := r.Get(r.Context, key, &job)
+	if k8serrors.IsNotFound(err) {
+		job = batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      r.Names.restore(),
+				Namespace: r.NamespacedName.Namespace,
+				Labels:    r.getAppLabels(componentRestore),
+			},
+			Spec: *defaultRestoreJobSpec(r.Names, bux),
+		}
+		useRegistry(&job.Spec.Template.Spec, bux.Spec.ImageRegistry)
+		if err = controllerutil.SetControllerReference(bux, &job, r.Scheme); err != nil {
+			return false, err
+		}
+		if err = r.Create(r.Context, &job); err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		r.setRestoreCondition(metav1.ConditionTrue, serverv1alpha1.RestoreReasonSucceeded,
+			fmt.Sprintf("restored from s3://%s", bux.Spec.RestoreFrom.S3.Bucket))
+		return true, nil
+	case jobFailed(&job):
+		r.setRestoreCondition(metav1.ConditionFalse, serverv1alpha1.RestoreReasonFailed,
+			fmt.Sprintf("restore job %s failed, delete the job to retry the restore", job.Name))
+		return false, nil
+	default:
+		r.setRestoreCondition(metav1.ConditionFalse, serverv1alpha1.RestoreReasonRunning,
+			fmt.Sprintf("waiting for restore job %s", job.Name))
+		r.requeueAfter(restoreRequeueInterval)
+		return false, nil
+	}
+}
+
+// serverDeployed returns true if the bux-server deployment exists
+func (r *BuxRequest) serverDeployed() (bool, error) {
+	dep := appsv1.Deployment{}
+	key := types.NamespacedName{Name: r.Names.server(), Namespace: r.NamespacedName.Namespace}
+	if err := r.Get(r.Context, key, &dep); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) setRestoreCondition(status metav1.ConditionStatus, reason, message string) {
+	r.setCondition(metav1.Condition{
+		Type:    serverv1alpha1.ConditionRestored,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// jobFailed returns true if the job gave up
+func jobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultRestoreJobSpec(names buxNames, bux *serverv1alpha1.Bux) *batchv1.JobSpec {
+	podLabels := names.labels(componentRestore)
+	backupFile := corev1.EnvVar{
+		Name:  "BACKUP_FILE",
+		Value: datastoreDumpFile,
+	}
+	envVars := append(s3EnvVars(bux, bux.Spec.RestoreFrom.S3),
+		backupFile,
+		corev1.EnvVar{
+			Name:  "RESTORE_KEY",
+			Value: bux.Spec.RestoreFrom.Key,
+		},
+	)
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "backup",
+			MountPath: "/backup",
+		},
+	}
+	// the validator refuses restores of a mongodb datastore
+	restore := corev1.Container{
+		Name:    "restore",
+		Image:   postgresqlImage,
+		Command: []string{"/bin/sh", "-c", postgresqlRestoreScript},
+		Env: []corev1.EnvVar{
+			backupFile,
+			{
+				Name:  "PGHOST",
+				Value: names.datastore(),
+			},
+			{
+				Name:  "PGPASSWORD",
+				Value: "postgres",
+			},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		SecurityContext:          restrictedSecurityContext(),
+		VolumeMounts:             volumeMounts,
+	}
+	return &batchv1.JobSpec{
+		BackoffLimit: pointer.Int32Ptr(3),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.Time{},
+				Labels:            podLabels,
+			},
+			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(defaultUID),
+				ServiceAccountName:           names.restore(),
+				AutomountServiceAccountToken: automountServiceAccountToken(bux.Spec.RestoreFrom.ServiceAccount),
+				RestartPolicy:                corev1.RestartPolicyNever,
+				InitContainers: []corev1.Container{
+					{
+						Name:                     "download",
+						Image:                    awsCliImage,
+						Command:                  []string{"/bin/sh", "-c", restoreDownloadScript},
+						Env:                      envVars,
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						VolumeMounts:             volumeMounts,
+					},
+				},
+				Containers: []corev1.Container{
+					restore,
+				},
+				Volumes: []corev1.Volume{
+					{
+						Name: "backup",
+						VolumeSource: corev1.VolumeSource{
+							EmptyDir: &corev1.EmptyDirVolumeSource{},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRestoreHoldsTheDeployment(t *testing.T) {
+	names := buxNames{instance: "shop"}
+	restoreJob := func(status batchv1.JobStatus) client.Object {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: names.restore(), Namespace: "payments"},
+			Status:     status,
+		}
+	}
+	for _, test := range []struct {
+		name     string
+		objs     []client.Object
+		reason   string
+		deployed bool
+		phase    serverv1alpha1.StepPhase
+	}{
+		{name: "created", reason: serverv1alpha1.RestoreReasonRunning, phase: serverv1alpha1.StepPhaseBlocked},
+		{name: "running", objs: []client.Object{restoreJob(batchv1.JobStatus{Active: 1})},
+			reason: serverv1alpha1.RestoreReasonRunning, phase: serverv1alpha1.StepPhaseBlocked},
+		{name: "failed", objs: []client.Object{restoreJob(batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+		}})}, reason: serverv1alpha1.RestoreReasonFailed, phase: serverv1alpha1.StepPhaseBlocked},
+		{name: "succeeded", objs: []client.Object{restoreJob(batchv1.JobStatus{Succeeded: 1})},
+			reason: serverv1alpha1.RestoreReasonSucceeded, deployed: true, phase: serverv1alpha1.StepPhaseDone},
+		{name: "already deployed", objs: []client.Object{
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: names.server(), Namespace: "payments"}},
+		}, reason: serverv1alpha1.RestoreReasonSkipped, deployed: true, phase: serverv1alpha1.StepPhaseDone},
+	} {
+		bux := &serverv1alpha1.Bux{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+			Spec: serverv1alpha1.BuxSpec{
+				Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+				RestoreFrom: &serverv1alpha1.RestoreConfig{
+					S3: &serverv1alpha1.S3Config{Bucket: "backups", CredentialsSecret: "s3"},
+				},
+			},
+		}
+		r := fakeRequest(t, bux, test.objs...)
+		// only the restore runs, the deployment step records that it was reached
+		deployed := false
+		steps := r.steps()
+		for i := range steps {
+			switch steps[i].name {
+			case "Restore":
+			case "Deployment":
+				steps[i].reconcile = func(logr.Logger) (bool, error) {
+					deployed = true
+					return true, nil
+				}
+			default:
+				steps[i].reconcile = func(logr.Logger) (bool, error) { return true, nil }
+			}
+		}
+		if err := r.runSteps(steps); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		condition := apimeta.FindStatusCondition(r.BuxStatus.Conditions, serverv1alpha1.ConditionRestored)
+		if condition == nil || condition.Reason != test.reason {
+			t.Errorf("%s: restored condition is %v, want reason %s", test.name, condition, test.reason)
+		}
+		if deployed != test.deployed {
+			t.Errorf("%s: deployed = %t, want %t", test.name, deployed, test.deployed)
+		}
+		for _, s := range r.BuxStatus.Steps {
+			if s.Name == "Deployment" && s.Phase != test.phase {
+				t.Errorf("%s: the deployment step is %s, want %s", test.name, s.Phase, test.phase)
+			}
+		}
+		err := r.Get(r.Context, types.NamespacedName{Name: names.restore(), Namespace: bux.Namespace}, &batchv1.Job{})
+		if restored := !k8serrors.IsNotFound(err); restored == (test.reason == serverv1alpha1.RestoreReasonSkipped) {
+			t.Errorf("%s: restore job exists = %t", test.name, restored)
+		}
+	}
+}
+
+func TestFinishedRestoreIsNotRepeated(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Spec: serverv1alpha1.BuxSpec{
+			RestoreFrom: &serverv1alpha1.RestoreConfig{
+				S3: &serverv1alpha1.S3Config{Bucket: "backups", CredentialsSecret: "s3"},
+			},
+		},
+	}
+	// the restore job is gone, e.g. deleted after the restore
+	r := fakeRequest(t, bux)
+	r.setRestoreCondition(metav1.ConditionTrue, serverv1alpha1.RestoreReasonSucceeded, "restored from s3://backups")
+	if done, err := r.ReconcileRestore(r.Log); err != nil || !done {
+		t.Fatalf("done = %t, %v", done, err)
+	}
+	err := r.Get(r.Context, types.NamespacedName{Name: r.Names.restore(), Namespace: bux.Namespace}, &batchv1.Job{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("the datastore is restored again: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// defaultTargetCPUUtilization is the cpu target when autoscaling sets no target
+const defaultTargetCPUUtilization = 80
+
+// ReconcileAutoscaling is the horizontal pod autoscaler of the bux deployment
+func (r *BuxRequest) ReconcileAutoscaling(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	if bux.Spec.Autoscaling == nil {
+		// Hand scaling back to spec.replicas
+		return true, r.removeAutoscaler(bux)
+	}
+	hpa := autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.server(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentServer),
+		},
+	}
+	err := r.apply(&hpa, func() error {
+		return r.updateAutoscaler(&hpa, bux)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) updateAutoscaler(hpa *autoscalingv2.HorizontalPodAutoscaler, bux *serverv1alpha1.Bux) error {
+	err := controllerutil.SetControllerReference(bux, hpa, r.Scheme)
+	if err != nil {
+		return err
+	}
+	hpa.Spec = *defaultAutoscalerSpec(r.Names, bux.Spec.Autoscaling)
+	return nil
+}
+
+// removeAutoscaler deletes the autoscaler of the bux deployment, if we own one
+func (r *BuxRequest) removeAutoscaler(bux *serverv1alpha1.Bux) error {
+	hpa := autoscalingv2.HorizontalPodAutoscaler{}
+	key := types.NamespacedName{Name: r.Names.server(), Namespace: r.NamespacedName.Namespace}
+	if err := r.Get(r.Context, key, &hpa); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(&hpa, bux) {
+		return nil
+	}
+	err := r.Delete(r.Context, &hpa)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ReconcileDisruptionBudget keeps bux-server serving through voluntary disruptions
+func (r *BuxRequest) ReconcileDisruptionBudget(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	pdb := policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.server(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentServer),
+		},
+	}
+	err := r.apply(&pdb, func() error {
+		return r.updateDisruptionBudget(&pdb, bux)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) updateDisruptionBudget(pdb *policyv1.PodDisruptionBudget, bux *serverv1alpha1.Bux) error {
+	err := controllerutil.SetControllerReference(bux, pdb, r.Scheme)
+	if err != nil {
+		return err
+	}
+	pdb.Spec = *defaultDisruptionBudgetSpec(r.Names)
+	return nil
+}
+
+func defaultAutoscalerSpec(names buxNames, autoscaling *serverv1alpha1.AutoscalingConfig) *autoscalingv2.HorizontalPodAutoscalerSpec {
+	minReplicas := autoscaling.MinReplicas
+	if minReplicas == nil {
+		minReplicas = pointer.Int32Ptr(1)
+	}
+	utilization := func(name corev1.ResourceName, target int32) autoscalingv2.MetricSpec {
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: name,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: pointer.Int32Ptr(target),
+				},
+			},
+		}
+	}
+	var metrics []autoscalingv2.MetricSpec
+	if autoscaling.TargetCPUUtilizationPercentage != nil {
+		metrics = append(metrics, utilization(corev1.ResourceCPU, *autoscaling.TargetCPUUtilizationPercentage))
+	}
+	if autoscaling.TargetMemoryUtilizationPercentage != nil {
+		metrics = append(metrics, utilization(corev1.ResourceMemory, *autoscaling.TargetMemoryUtilizationPercentage))
+	}
+	if len(metrics) == 0 {
+		metrics = append(metrics, utilization(corev1.ResourceCPU, defaultTargetCPUUtilization))
+	}
+	return &autoscalingv2.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       names.server(),
+		},
+		MinReplicas: minReplicas,
+		MaxReplicas: autoscaling.MaxReplicas,
+		Metrics:     metrics,
+	}
+}
+
+func defaultDisruptionBudgetSpec(names buxNames) *policyv1.PodDisruptionBudgetSpec {
+	maxUnavailable := intstr.FromInt(1)
+	return &policyv1.PodDisruptionBudgetSpec{
+		MaxUnavailable: &maxUnavailable,
+		Selector:       metav1.SetAsLabelSelector(names.selector(componentServer)),
+	}
+}
@@ -1,8 +1,6 @@
 package controllers
 
 import (
-	"fmt"
-
 	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -13,24 +11,17 @@ import (
 )
 
 // ReconcileIngress is the ingress
-func (r *BuxReconciler) ReconcileIngress(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
-	// Skip if domain isn't set
-	if bux.Spec.Domain == "" {
-		return false, nil
-	}
+func (r *BuxRequest) ReconcileIngress(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	ingress := networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux",
+			Name:      r.Names.server(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentServer),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &ingress, func() error {
-		return r.updateIngress(&ingress, &bux)
+	err := r.apply(&ingress, func() error {
+		return r.updateIngress(&ingress, bux)
 	})
 	if err != nil {
 		return false, err
@@ -39,19 +30,17 @@ func (r *BuxReconciler) ReconcileIngress(_ logr.Logger) (bool, error) {
 }
 
 // ReconcileService is the service
-func (r *BuxReconciler) ReconcileService(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileService(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	svc := corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux",
+			Name:      r.Names.server(),
 			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentServer),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &svc, func() error {
-		return r.updateService(&svc, &bux)
+	err := r.apply(&svc, func() error {
+		return r.updateService(&svc, bux)
 	})
 	if err != nil {
 		return false, err
@@ -59,7 +48,7 @@ func (r *BuxReconciler) ReconcileService(_ logr.Logger) (bool, error) {
 	return true, nil
 }
 
-func (r *BuxReconciler) updateIngress(ingress *networkingv1.Ingress, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateIngress(ingress *networkingv1.Ingress, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, ingress, r.Scheme)
 	if err != nil {
 		return err
@@ -68,37 +57,43 @@ func (r *BuxReconciler) updateIngress(ingress *networkingv1.Ingress, bux *server
 		if ingress.Annotations == nil {
 			ingress.Annotations = make(map[string]string)
 		}
-		ingress.Annotations["cert-manager.io/cluster-issuer"] = bux.Spec.ClusterIssuer
+		ingress.Annotations[clusterIssuerAnnotation] = bux.Spec.ClusterIssuer
 		ingress.Annotations["nginx.ingress.kubernetes.io/enable-cors"] = "true"
 		ingress.Annotations["nginx.ingress.kubernetes.io/cors-allow-headers"] = "bux-auth-time,bux-auth-xpub,bux-auth-hash,bux-auth-nonce,bux-auth-signature,DNT,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Range,Authorization"
 	}
-	ingress.Spec = *defaultIngressSpec(bux)
+	ingress.Spec = *defaultIngressSpec(r.Names, bux)
+	if maintenanceResponse(bux) != nil {
+		// Route to the maintenance response while bux-server is down
+		backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+		backend.Name = r.Names.maintenance()
+		backend.Port.Number = maintenancePort
+	}
 	return nil
 }
 
-func (r *BuxReconciler) updateService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, svc, r.Scheme)
 	if err != nil {
 		return err
 	}
-	svc.Spec = *defaultServiceSpec()
+	svc.Spec = *defaultServiceSpec(r.Names)
 	return nil
 }
 
-func defaultIngressSpec(bux *serverv1alpha1.Bux) *networkingv1.IngressSpec {
+func defaultIngressSpec(names buxNames, bux *serverv1alpha1.Bux) *networkingv1.IngressSpec {
 	pathType := networkingv1.PathTypeImplementationSpecific
 	return &networkingv1.IngressSpec{
 		TLS: []networkingv1.IngressTLS{
 			{
 				Hosts: []string{
-					fmt.Sprintf("%s.%s", bux.Namespace, bux.Spec.Domain),
+					names.host(bux),
 				},
-				SecretName: "bux-tls",
+				SecretName: names.child("tls"),
 			},
 		},
 		Rules: []networkingv1.IngressRule{
 			{
-				Host: fmt.Sprintf("%s.%s", bux.Namespace, bux.Spec.Domain),
+				Host: names.host(bux),
 				IngressRuleValue: networkingv1.IngressRuleValue{
 					HTTP: &networkingv1.HTTPIngressRuleValue{
 						Paths: []networkingv1.HTTPIngressPath{
@@ -106,7 +101,7 @@ func defaultIngressSpec(bux *serverv1alpha1.Bux) *networkingv1.IngressSpec {
 								PathType: &pathType,
 								Backend: networkingv1.IngressBackend{
 									Service: &networkingv1.IngressServiceBackend{
-										Name: "bux",
+										Name: names.server(),
 										Port: networkingv1.ServiceBackendPort{
 											Number: int32(3003),
 										},
@@ -121,10 +116,8 @@ func defaultIngressSpec(bux *serverv1alpha1.Bux) *networkingv1.IngressSpec {
 	}
 }
 
-func defaultServiceSpec() *corev1.ServiceSpec {
-	labels := map[string]string{
-		"app": "bux",
-	}
+func defaultServiceSpec(names buxNames) *corev1.ServiceSpec {
+	labels := names.selector(componentServer)
 	return &corev1.ServiceSpec{
 		Selector: labels,
 		Type:     corev1.ServiceTypeClusterIP,
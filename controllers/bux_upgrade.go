@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// latestVersion is the image tag used when spec.version is not set
+	latestVersion = "latest"
+
+	// defaultUpgradeTimeout is how long new pods have to become ready
+	defaultUpgradeTimeout = 10 * time.Minute
+
+	// upgradeRequeueInterval is how often we check on a running upgrade
+	upgradeRequeueInterval = 15 * time.Second
+)
+
+// ReconcileUpgrade moves bux-server from status.version to spec.version. It
+// takes a backup, lets ReconcileMigration migrate for the new image, lets
+// ReconcileDeployment roll the new image and reverts to the previous version
+// when the new pods are not ready within spec.upgradeTimeout. The version the
+// other steps should run is decided by desiredServerVersion.
+func (r *BuxRequest) ReconcileUpgrade(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	target := bux.Spec.Version
+	if target == "" {
+		target = latestVersion
+	}
+	if r.BuxStatus.Version == "" {
+		current, err := r.deployedServerVersion()
+		if err != nil {
+			return false, err
+		}
+		if current == "" {
+			// First install, there is nothing to upgrade from
+			current = target
+		}
+		r.BuxStatus.Version = current
+	}
+	current := r.BuxStatus.Version
+	upgrade := r.BuxStatus.Upgrade
+
+	if target == current {
+		// Also covers going back to the running version after a failed upgrade
+		r.BuxStatus.Upgrade = nil
+		r.setUpgradeCondition(metav1.ConditionTrue, serverv1alpha1.UpgradeReasonComplete,
+			fmt.Sprintf("running %s", current))
+		return true, nil
+	}
+	if upgrade != nil && upgrade.ToVersion == target &&
+		(upgrade.Phase == serverv1alpha1.UpgradePhaseBlocked || upgrade.Phase == serverv1alpha1.UpgradePhaseRolledBack) {
+		// Keep running the current version until spec.version changes
+		return true, nil
+	}
+	if upgrade == nil || upgrade.ToVersion != target {
+		if downgradesSchema(current, target) {
+			r.setUpgradePhase(current, target, serverv1alpha1.UpgradePhaseBlocked)
+			r.setUpgradeCondition(metav1.ConditionFalse, serverv1alpha1.UpgradeReasonDowngradeRefused,
+				fmt.Sprintf("%s has an older schema than the running %s", target, current))
+			return true, nil
+		}
+		r.setUpgradePhase(current, target, serverv1alpha1.UpgradePhaseBackingUp)
+	}
+
+	switch r.BuxStatus.Upgrade.Phase {
+	case serverv1alpha1.UpgradePhaseBackingUp:
+		done, err := r.reconcilePreUpgradeBackup(bux, target)
+		if err != nil || !done {
+			return err == nil, err
+		}
+		r.setUpgradePhase(current, target, serverv1alpha1.UpgradePhaseMigrating)
+		fallthrough
+	case serverv1alpha1.UpgradePhaseMigrating:
+		done, err := r.migrated(bux, target)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			// ReconcileMigration reports on and holds the migration
+			r.setUpgradeCondition(metav1.ConditionFalse, serverv1alpha1.UpgradeReasonInProgress,
+				fmt.Sprintf("migrating from %s to %s", current, target))
+			r.requeueAfter(upgradeRequeueInterval)
+			return true, nil
+		}
+		r.setUpgradePhase(current, target, serverv1alpha1.UpgradePhaseRollingOut)
+		fallthrough
+	case serverv1alpha1.UpgradePhaseRollingOut:
+		return r.reconcileUpgradeRollout(bux, current, target)
+	}
+	return true, nil
+}
+
+// reconcilePreUpgradeBackup runs the backup job once for the target version,
+// it returns true when the backup succeeded or backups aren't configured
+func (r *BuxRequest) reconcilePreUpgradeBackup(bux *serverv1alpha1.Bux, target string) (bool, error) {
+	if bux.Spec.Backup == nil {
+		return true, nil
+	}
+	name := r.Names.preUpgrade() + "-" + shortHash(target)
+	job := batchv1.Job{}
+	key := types.NamespacedName{Name: name, Namespace: r.NamespacedName.Namespace}
+	err := r.Get(r.Context, key, &job)
+	if k8serrors.IsNotFound(err) {
+		job = batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: r.NamespacedName.Namespace,
+				Labels:    r.getAppLabels(componentBackup),
+			},
+			Spec: defaultBackupCronJobSpec(r.Names, bux).JobTemplate.Spec,
+		}
+		useRegistry(&job.Spec.Template.Spec, bux.Spec.ImageRegistry)
+		if err = controllerutil.SetControllerReference(bux, &job, r.Scheme); err != nil {
+			return false, err
+		}
+		if err = r.Create(r.Context, &job); err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		return true, nil
+	case jobFailed(&job):
+		r.setUpgradeCondition(metav1.ConditionFalse, serverv1alpha1.UpgradeReasonBackupFailed,
+			fmt.Sprintf("pre-upgrade backup job %s failed, delete the job to retry the upgrade to %s", name, target))
+		return false, nil
+	default:
+		r.setUpgradeCondition(metav1.ConditionFalse, serverv1alpha1.UpgradeReasonInProgress,
+			fmt.Sprintf("waiting for pre-upgrade backup job %s", name))
+		r.requeueAfter(upgradeRequeueInterval)
+		return false, nil
+	}
+}
+
+// migrated returns true when the migration job of the target version succeeded,
+// or when migrations are managed outside of the controller
+func (r *BuxRequest) migrated(bux *serverv1alpha1.Bux, target string) (bool, error) {
+	if !bux.Spec.Configuration.AutoMigrate {
+		return true, nil
+	}
+	job := batchv1.Job{}
+	key := types.NamespacedName{Name: migrationJobName(r.Names, buxImage(target)), Namespace: r.NamespacedName.Namespace}
+	if err := r.Get(r.Context, key, &job); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return jobMigrated(&job), nil
+}
+
+// reconcileUpgradeRollout waits for the bux deployment to run the target
+// version, and rolls back to the current version when that takes too long
+func (r *BuxRequest) reconcileUpgradeRollout(bux *serverv1alpha1.Bux, current, target string) (bool, error) {
+	dep := appsv1.Deployment{}
+	key := types.NamespacedName{Name: r.Names.server(), Namespace: r.NamespacedName.Namespace}
+	err := r.Get(r.Context, key, &dep)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil && deploymentRolledOut(&dep, withRegistry(bux.Spec.ImageRegistry, buxImage(target))) {
+		r.BuxStatus.Version = target
+		r.BuxStatus.Upgrade = nil
+		r.setUpgradeCondition(metav1.ConditionTrue, serverv1alpha1.UpgradeReasonComplete,
+			fmt.Sprintf("upgraded from %s to %s", current, target))
+		return true, nil
+	}
+
+	timeout := defaultUpgradeTimeout
+	if bux.Spec.UpgradeTimeout != nil {
+		timeout = bux.Spec.UpgradeTimeout.Duration
+	}
+	if time.Since(r.BuxStatus.Upgrade.PhaseStartedAt.Time) > timeout {
+		r.setUpgradePhase(current, target, serverv1alpha1.UpgradePhaseRolledBack)
+		r.setUpgradeCondition(metav1.ConditionFalse, serverv1alpha1.UpgradeReasonRolledBack,
+			fmt.Sprintf("%s was not ready within %s, rolled back to %s", target, timeout, current))
+		return true, nil
+	}
+	r.setUpgradeCondition(metav1.ConditionFalse, serverv1alpha1.UpgradeReasonInProgress,
+		fmt.Sprintf("rolling out %s", target))
+	r.requeueAfter(upgradeRequeueInterval)
+	return true, nil
+}
+
+// deployedServerVersion is the image tag of an existing bux deployment, from
+// before the version was recorded in the status
+func (r *BuxRequest) deployedServerVersion() (string, error) {
+	dep := appsv1.Deployment{}
+	key := types.NamespacedName{Name: r.Names.server(), Namespace: r.NamespacedName.Namespace}
+	if err := r.Get(r.Context, key, &dep); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		if container.Name != "bux" {
+			continue
+		}
+		if i := strings.LastIndex(container.Image, ":"); i > strings.LastIndex(container.Image, "/") {
+			return container.Image[i+1:], nil
+		}
+	}
+	return latestVersion, nil
+}
+
+func (r *BuxRequest) setUpgradePhase(from, to string, phase serverv1alpha1.UpgradePhase) {
+	r.BuxStatus.Upgrade = &serverv1alpha1.UpgradeStatus{
+		FromVersion:    from,
+		ToVersion:      to,
+		Phase:          phase,
+		PhaseStartedAt: metav1.Now(),
+	}
+}
+
+func (r *BuxRequest) setUpgradeCondition(status metav1.ConditionStatus, reason, message string) {
+	r.setCondition(metav1.Condition{
+		Type:    serverv1alpha1.ConditionUpgraded,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// desiredServerVersion is the version the migration job and the bux
+// deployment should run, the target only once the upgrade is past its backup
+func desiredServerVersion(status *serverv1alpha1.BuxStatus) string {
+	if upgrade := status.Upgrade; upgrade != nil && (upgrade.Phase == serverv1alpha1.UpgradePhaseMigrating ||
+		upgrade.Phase == serverv1alpha1.UpgradePhaseRollingOut) {
+		return upgrade.ToVersion
+	}
+	return status.Version
+}
+
+// downgradesSchema returns true if target is older than current by more than a
+// patch release, since migrations can't be undone. Versions that aren't semantic,
+// like latest, can't be ordered and are allowed.
+func downgradesSchema(current, target string) bool {
+	from, err := version.ParseSemantic(current)
+	if err != nil {
+		return false
+	}
+	to, err := version.ParseSemantic(target)
+	if err != nil {
+		return false
+	}
+	if from.Major() != to.Major() {
+		return to.Major() < from.Major()
+	}
+	return to.Minor() < from.Minor()
+}
+
+// deploymentRolledOut returns true if every replica of the deployment runs image and is available
+func deploymentRolledOut(dep *appsv1.Deployment, image string) bool {
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		if container.Name == "bux" && container.Image != image {
+			return false
+		}
+	}
+	return deploymentAvailable(dep)
+}
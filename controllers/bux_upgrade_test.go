@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestUpgradePhases(t *testing.T) {
+	names := buxNames{instance: "shop"}
+	deployment := func(version string, available bool) client.Object {
+		dep := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: names.server(), Namespace: "payments"},
+			Spec:       *defaultDeploymentSpec(names, version, pointer.Int32Ptr(1)),
+		}
+		if available {
+			dep.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		}
+		return dep
+	}
+	backupJob := func(target string, status batchv1.JobStatus) client.Object {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: names.preUpgrade() + "-" + shortHash(target), Namespace: "payments"},
+			Status:     status,
+		}
+	}
+	migrationJob := func(target string, status batchv1.JobStatus) client.Object {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: migrationJobName(names, buxImage(target)), Namespace: "payments"},
+			Status:     status,
+		}
+	}
+	upgrade := func(phase serverv1alpha1.UpgradePhase, to string, age time.Duration) *serverv1alpha1.UpgradeStatus {
+		return &serverv1alpha1.UpgradeStatus{
+			FromVersion: "v0.3.0", ToVersion: to, Phase: phase,
+			PhaseStartedAt: metav1.NewTime(time.Now().Add(-age)),
+		}
+	}
+	failed := batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}}
+	succeeded := batchv1.JobStatus{Succeeded: 1}
+
+	for _, test := range []struct {
+		name        string
+		target      string
+		backup      bool
+		autoMigrate bool
+		version     string
+		upgrade     *serverv1alpha1.UpgradeStatus
+		objs        []client.Object
+		// the outcome
+		phase   serverv1alpha1.UpgradePhase
+		reason  string
+		running string
+		desired string
+	}{
+		{name: "first install", target: "v0.3.1",
+			reason: serverv1alpha1.UpgradeReasonComplete, running: "v0.3.1", desired: "v0.3.1"},
+		{name: "deployed before versions were recorded", target: "v0.3.1",
+			objs:  []client.Object{deployment("v0.3.0", true)},
+			phase: serverv1alpha1.UpgradePhaseRollingOut, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.1"},
+		{name: "downgrade", target: "v0.2.0", version: "v0.3.0",
+			phase: serverv1alpha1.UpgradePhaseBlocked, reason: serverv1alpha1.UpgradeReasonDowngradeRefused,
+			running: "v0.3.0", desired: "v0.3.0"},
+		{name: "patch downgrade", target: "v0.3.0", version: "v0.3.1",
+			phase: serverv1alpha1.UpgradePhaseRollingOut, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.1", desired: "v0.3.0"},
+		{name: "backing up", target: "v0.3.1", version: "v0.3.0", backup: true,
+			phase: serverv1alpha1.UpgradePhaseBackingUp, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.0"},
+		{name: "backup failed", target: "v0.3.1", version: "v0.3.0", backup: true,
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseBackingUp, "v0.3.1", time.Minute),
+			objs:    []client.Object{backupJob("v0.3.1", failed)},
+			phase:   serverv1alpha1.UpgradePhaseBackingUp, reason: serverv1alpha1.UpgradeReasonBackupFailed,
+			running: "v0.3.0", desired: "v0.3.0"},
+		{name: "backed up", target: "v0.3.1", version: "v0.3.0", backup: true, autoMigrate: true,
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseBackingUp, "v0.3.1", time.Minute),
+			objs:    []client.Object{backupJob("v0.3.1", succeeded)},
+			phase:   serverv1alpha1.UpgradePhaseMigrating, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.1"},
+		{name: "migration failed", target: "v0.3.1", version: "v0.3.0", autoMigrate: true,
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseMigrating, "v0.3.1", time.Minute),
+			objs:    []client.Object{migrationJob("v0.3.1", failed)},
+			phase:   serverv1alpha1.UpgradePhaseMigrating, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.1"},
+		{name: "migrated", target: "v0.3.1", version: "v0.3.0", autoMigrate: true,
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseMigrating, "v0.3.1", time.Minute),
+			objs:    []client.Object{migrationJob("v0.3.1", succeeded), deployment("v0.3.0", true)},
+			phase:   serverv1alpha1.UpgradePhaseRollingOut, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.1"},
+		{name: "rolling out", target: "v0.3.1", version: "v0.3.0",
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseRollingOut, "v0.3.1", time.Minute),
+			objs:    []client.Object{deployment("v0.3.1", false)},
+			phase:   serverv1alpha1.UpgradePhaseRollingOut, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.1"},
+		{name: "rolled out", target: "v0.3.1", version: "v0.3.0",
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseRollingOut, "v0.3.1", time.Minute),
+			objs:    []client.Object{deployment("v0.3.1", true)},
+			reason:  serverv1alpha1.UpgradeReasonComplete, running: "v0.3.1", desired: "v0.3.1"},
+		{name: "not ready in time", target: "v0.3.1", version: "v0.3.0",
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseRollingOut, "v0.3.1", time.Hour),
+			objs:    []client.Object{deployment("v0.3.1", false)},
+			phase:   serverv1alpha1.UpgradePhaseRolledBack, reason: serverv1alpha1.UpgradeReasonRolledBack,
+			running: "v0.3.0", desired: "v0.3.0"},
+		{name: "rolled back", target: "v0.3.1", version: "v0.3.0",
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseRolledBack, "v0.3.1", time.Hour),
+			phase:   serverv1alpha1.UpgradePhaseRolledBack, running: "v0.3.0", desired: "v0.3.0"},
+		{name: "back to the running version", target: "v0.3.0", version: "v0.3.0",
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseRolledBack, "v0.3.1", time.Hour),
+			reason:  serverv1alpha1.UpgradeReasonComplete, running: "v0.3.0", desired: "v0.3.0"},
+		{name: "another version after a rollback", target: "v0.3.2", version: "v0.3.0",
+			upgrade: upgrade(serverv1alpha1.UpgradePhaseRolledBack, "v0.3.1", time.Hour),
+			objs:    []client.Object{deployment("v0.3.0", true)},
+			phase:   serverv1alpha1.UpgradePhaseRollingOut, reason: serverv1alpha1.UpgradeReasonInProgress,
+			running: "v0.3.0", desired: "v0.3.2"},
+	} {
+		bux := &serverv1alpha1.Bux{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+			Spec: serverv1alpha1.BuxSpec{
+				Version:       test.target,
+				Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql", AutoMigrate: test.autoMigrate},
+			},
+		}
+		if test.backup {
+			bux.Spec.Backup = &serverv1alpha1.BackupConfig{
+				Schedule: "0 3 * * *",
+				S3:       &serverv1alpha1.S3Config{Bucket: "backups", CredentialsSecret: "s3"},
+			}
+		}
+		r := fakeRequest(t, bux, test.objs...)
+		r.BuxStatus.Version = test.version
+		r.BuxStatus.Upgrade = test.upgrade
+		if _, err := r.ReconcileUpgrade(r.Log); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		var phase serverv1alpha1.UpgradePhase
+		if r.BuxStatus.Upgrade != nil {
+			phase = r.BuxStatus.Upgrade.Phase
+		}
+		if phase != test.phase {
+			t.Errorf("%s: phase = %q, want %q", test.name, phase, test.phase)
+		}
+		if test.reason != "" {
+			condition := apimeta.FindStatusCondition(r.BuxStatus.Conditions, serverv1alpha1.ConditionUpgraded)
+			if condition == nil || condition.Reason != test.reason {
+				t.Errorf("%s: upgraded condition is %v, want reason %s", test.name, condition, test.reason)
+			}
+		}
+		if r.BuxStatus.Version != test.running {
+			t.Errorf("%s: running %s, want %s", test.name, r.BuxStatus.Version, test.running)
+		}
+		if desired := desiredServerVersion(r.BuxStatus); desired != test.desired {
+			t.Errorf("%s: the deployment and migration run %s, want %s", test.name, desired, test.desired)
+		}
+	}
+}
+
+func TestPreUpgradeBackupPullsFromTheImageRegistry(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Spec: serverv1alpha1.BuxSpec{
+			ImageRegistry: "registry.internal:5000/mirror",
+			Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+			Backup: &serverv1alpha1.BackupConfig{
+				Schedule: "0 3 * * *",
+				S3:       &serverv1alpha1.S3Config{Bucket: "backups", CredentialsSecret: "s3"},
+			},
+		},
+	}
+	r := fakeRequest(t, bux)
+	if done, err := r.reconcilePreUpgradeBackup(bux, "v0.3.1"); err != nil || done {
+		t.Fatalf("done = %t, %v", done, err)
+	}
+	job := batchv1.Job{}
+	key := types.NamespacedName{Name: r.Names.preUpgrade() + "-" + shortHash("v0.3.1"), Namespace: bux.Namespace}
+	if err := r.Get(r.Context, key, &job); err != nil {
+		t.Fatal(err)
+	}
+	pod := job.Spec.Template.Spec
+	for _, c := range append(pod.InitContainers, pod.Containers...) {
+		if !strings.HasPrefix(c.Image, bux.Spec.ImageRegistry+"/") {
+			t.Errorf("container %s pulls %s", c.Name, c.Image)
+		}
+	}
+}
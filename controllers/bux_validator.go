@@ -3,16 +3,19 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 
 	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 // Validate will run validations
-func (r *BuxReconciler) Validate(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
+func (r *BuxRequest) Validate(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	if !r.Names.legacy && len(bux.Name) > maxInstanceNameLength {
+		return false, fmt.Errorf("the name of a Bux is at most %d characters", maxInstanceNameLength)
 	}
 	if bux.Spec.Configuration.Datastore == "" {
 		return false, errors.New("missing datastore configuration")
@@ -20,6 +23,33 @@ func (r *BuxReconciler) Validate(_ logr.Logger) (bool, error) {
 	if err := validateDatastore(bux.Spec.Configuration.Datastore); err != nil {
 		return false, err
 	}
+	if err := validateBackup(bux.Spec.Backup); err != nil {
+		return false, err
+	}
+	// the backups and restores are pg_dump archives
+	if bux.Spec.Configuration.Datastore != "postgresql" && (bux.Spec.Backup != nil || bux.Spec.RestoreFrom != nil) {
+		return false, fmt.Errorf("backup and restoreFrom are not supported with the %s datastore",
+			bux.Spec.Configuration.Datastore)
+	}
+	if bux.Spec.Profile == serverv1alpha1.ProfileLite {
+		if errs := serverv1alpha1.ValidateLiteProfile(&bux.Spec, field.NewPath("spec")); len(errs) > 0 {
+			return false, errs.ToAggregate()
+		}
+	}
+	if err := validateRedis(bux.Spec.Redis); err != nil {
+		return false, err
+	}
+	if err := validateAutoscaling(bux.Spec.Autoscaling); err != nil {
+		return false, err
+	}
+	if err := validateMaintenance(bux.Spec.Maintenance); err != nil {
+		return false, err
+	}
+	if bux.Spec.RestoreFrom != nil {
+		if err := validateS3(bux.Spec.RestoreFrom.S3); err != nil {
+			return false, fmt.Errorf("invalid restoreFrom: %w", err)
+		}
+	}
 	return true, nil
 }
 
@@ -33,3 +63,73 @@ func validateDatastore(datastore string) error {
 		return fmt.Errorf("unsupported datastore %s", datastore)
 	}
 }
+
+func validateBackup(backup *serverv1alpha1.BackupConfig) error {
+	if backup == nil {
+		return nil
+	}
+	if backup.Schedule == "" {
+		return errors.New("missing backup schedule")
+	}
+	if backup.Retention != nil && *backup.Retention < 1 {
+		return errors.New("backup retention must keep at least one backup")
+	}
+	return validateS3(backup.S3)
+}
+
+func validateS3(s3 *serverv1alpha1.S3Config) error {
+	if s3 == nil {
+		return errors.New("missing s3 configuration")
+	}
+	if s3.Bucket == "" {
+		return errors.New("missing s3 bucket")
+	}
+	if s3.CredentialsSecret == "" {
+		return errors.New("missing s3 credentials secret")
+	}
+	return nil
+}
+
+func validateRedis(redis *serverv1alpha1.RedisConfig) error {
+	if redis == nil {
+		return nil
+	}
+	if redis.URL == "" {
+		if redis.PasswordSecret != nil {
+			return errors.New("redis passwordSecret is only used with an external redis url")
+		}
+		return nil
+	}
+	redisURL, err := url.Parse(redis.URL)
+	if err != nil {
+		return fmt.Errorf("invalid redis url: %w", err)
+	}
+	if redisURL.Scheme != "redis" && redisURL.Scheme != "rediss" {
+		return fmt.Errorf("unsupported redis url scheme %s", redisURL.Scheme)
+	}
+	return nil
+}
+
+func validateAutoscaling(autoscaling *serverv1alpha1.AutoscalingConfig) error {
+	if autoscaling == nil {
+		return nil
+	}
+	if autoscaling.MaxReplicas < 1 {
+		return errors.New("autoscaling maxReplicas must be at least 1")
+	}
+	if autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		return errors.New("autoscaling minReplicas cannot exceed maxReplicas")
+	}
+	return nil
+}
+
+func validateMaintenance(maintenance *serverv1alpha1.MaintenanceConfig) error {
+	if maintenance == nil || maintenance.Response == nil {
+		return nil
+	}
+	// The content type is quoted in the nginx config that serves the response
+	if strings.ContainsAny(maintenance.Response.ContentType, "\"\\$\r\n") {
+		return errors.New("invalid maintenance response content type")
+	}
+	return nil
+}
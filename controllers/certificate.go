@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch
+
+// clusterIssuerAnnotation has cert-manager issue a certificate for the TLS
+// hosts of an ingress
+const clusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+
+// certificateGVK is the cert-manager Certificate, which is read unstructured
+// so that cert-manager is not a dependency
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// CertManagerInstalled returns true if the certificate CRD of cert-manager is
+// served by the cluster
+func CertManagerInstalled(config *rest.Config) (bool, error) {
+	return resourceServed(config, certificateGVK.GroupVersion().String(), "certificates")
+}
+
+// newCertificate is an empty cert-manager Certificate
+func newCertificate() *unstructured.Unstructured {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	return certificate
+}
+
+// certificateReady returns true if the Ready condition of the certificate is true
+func certificateReady(certificate *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(certificate.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Ready" {
+			return condition["status"] == string(metav1.ConditionTrue)
+		}
+	}
+	return false
+}
+
+// certificateRequests maps a certificate to the Bux of the ingress it was
+// issued for, cert-manager makes the ingress its owner
+func (r *BuxReconciler) certificateRequests(obj client.Object) []reconcile.Request {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != "Ingress" {
+		return nil
+	}
+	ctx := context.Background()
+	ingress := networkingv1.Ingress{}
+	key := types.NamespacedName{Name: owner.Name, Namespace: obj.GetNamespace()}
+	if err := r.Get(ctx, key, &ingress); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.FromContext(ctx).Error(err, "unable to get the Ingress of the Certificate")
+		}
+		return nil
+	}
+	bux := metav1.GetControllerOf(&ingress)
+	if bux == nil || bux.Kind != serverv1alpha1.Kind || bux.APIVersion != serverv1alpha1.GroupVersion.String() {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: bux.Name, Namespace: ingress.Namespace}},
+	}
+}
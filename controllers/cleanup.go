@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	redisv1beta1 "github.com/murray-distributed-technologies/redis-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// removeObjects deletes the objects called name of the types of objs that the
+// Bux controls, objects it doesn't control are left alone
+func (r *BuxRequest) removeObjects(bux *serverv1alpha1.Bux, name string, objs ...client.Object) error {
+	key := types.NamespacedName{Name: name, Namespace: r.NamespacedName.Namespace}
+	for _, obj := range objs {
+		if err := r.Get(r.Context, key, obj); err != nil {
+			if err = client.IgnoreNotFound(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if !metav1.IsControlledBy(obj, bux) {
+			continue
+		}
+		if err := client.IgnoreNotFound(r.Delete(r.Context, obj)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removing is the remove function of a step that deletes the objects called
+// name of the types of objs
+func (r *BuxRequest) removing(name string, objs ...client.Object) func(*serverv1alpha1.Bux) error {
+	return func(bux *serverv1alpha1.Bux) error {
+		return r.removeObjects(bux, name, objs...)
+	}
+}
+
+// removeBackup deletes the backup cronjob, its service account and the status
+// of its backups
+func (r *BuxRequest) removeBackup(bux *serverv1alpha1.Bux) error {
+	r.BuxStatus.Backup = nil
+	return r.removeObjects(bux, r.Names.backup(), &batchv1.CronJob{}, &corev1.ServiceAccount{})
+}
+
+// removeConsoleMongoPVC deletes the console mongo volume when its retention
+// policy says so, it is kept by default
+func (r *BuxRequest) removeConsoleMongoPVC(bux *serverv1alpha1.Bux) error {
+	r.removeCondition(serverv1alpha1.ConditionConsoleMongoStorageReady)
+	var storage *serverv1alpha1.StorageConfig
+	if bux.Spec.ConsoleMongo != nil {
+		storage = bux.Spec.ConsoleMongo.Storage
+	}
+	if volumeRetentionPolicy(storage) != serverv1alpha1.VolumeRetentionPolicyDelete {
+		return nil
+	}
+	return r.removeObjects(bux, r.Names.consoleMongo(), &corev1.PersistentVolumeClaim{})
+}
+
+// removeRedis deletes the in-cluster redis, of the redis operator or our own,
+// its service account and network policy, and its volume when the retention
+// policy of its storage says so
+func (r *BuxRequest) removeRedis(bux *serverv1alpha1.Bux) error {
+	objs := []client.Object{&appsv1.StatefulSet{}, &corev1.Service{}}
+	if r.RedisOperator {
+		objs = append(objs, &redisv1beta1.Redis{})
+	}
+	if err := r.removeObjects(bux, r.Names.redis(), objs...); err != nil {
+		return err
+	}
+	err := r.removeObjects(bux, r.Names.redisServiceAccount(), &corev1.ServiceAccount{}, &networkingv1.NetworkPolicy{})
+	if err != nil {
+		return err
+	}
+	var storage *serverv1alpha1.StorageConfig
+	if bux.Spec.Redis != nil {
+		storage = bux.Spec.Redis.Storage
+	}
+	if volumeRetentionPolicy(storage) != serverv1alpha1.VolumeRetentionPolicyDelete {
+		return nil
+	}
+	return r.removeRedisClaims()
+}
+
+// removeRedisClaims deletes the volume of the redis pod. The statefulsets of
+// the redis operator and our own name it after their claim template, the
+// operator's after the redis, ours "data", and nothing owns it.
+func (r *BuxRequest) removeRedisClaims() error {
+	redis := r.Names.redis()
+	for _, name := range []string{redis + "-" + redis + "-0", "data-" + redis + "-0"} {
+		pvc := corev1.PersistentVolumeClaim{}
+		key := types.NamespacedName{Name: name, Namespace: r.NamespacedName.Namespace}
+		if err := r.Get(r.Context, key, &pvc); err != nil {
+			if err = client.IgnoreNotFound(err); err != nil {
+				return err
+			}
+			continue
+		}
+		if metav1.GetControllerOf(&pvc) != nil {
+			continue
+		}
+		if err := client.IgnoreNotFound(r.Delete(r.Context, &pvc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// volumeRetentionPolicy is the retention policy of a volume
+func volumeRetentionPolicy(storage *serverv1alpha1.StorageConfig) serverv1alpha1.VolumeRetentionPolicy {
+	if storage == nil || storage.WhenDisabled == "" {
+		return serverv1alpha1.VolumeRetentionPolicyRetain
+	}
+	return storage.WhenDisabled
+}
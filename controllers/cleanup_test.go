@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	redisv1beta1 "github.com/murray-distributed-technologies/redis-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestTurningTheConsoleOffRemovesItsObjects(t *testing.T) {
+	scheme := testScheme(t)
+	for _, policy := range []serverv1alpha1.VolumeRetentionPolicy{"", serverv1alpha1.VolumeRetentionPolicyDelete} {
+		bux := &serverv1alpha1.Bux{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+			Spec: serverv1alpha1.BuxSpec{
+				ConsoleMongo: &serverv1alpha1.ConsoleMongoConfig{
+					Storage: &serverv1alpha1.StorageConfig{WhenDisabled: policy},
+				},
+			},
+		}
+		names := buxNames{instance: "shop"}
+		owned := func(obj client.Object, name string) client.Object {
+			obj.SetName(name)
+			obj.SetNamespace(bux.Namespace)
+			if err := controllerutil.SetControllerReference(bux, obj, scheme); err != nil {
+				t.Fatal(err)
+			}
+			return obj
+		}
+		// not the console's, it is created by hand under the same name
+		foreign := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: names.console(), Namespace: bux.Namespace}}
+		r := fakeRequest(t, bux,
+			owned(&appsv1.Deployment{}, names.console()),
+			owned(&corev1.Service{}, names.console()),
+			owned(&appsv1.Deployment{}, names.consoleMongo()),
+			owned(&corev1.Service{}, names.consoleMongodb()),
+			owned(&corev1.PersistentVolumeClaim{}, names.consoleMongo()),
+			owned(&corev1.ServiceAccount{}, names.console()),
+			owned(&corev1.ServiceAccount{}, names.consoleMongo()),
+			owned(&networkingv1.NetworkPolicy{}, names.consoleMongo()),
+			foreign,
+		)
+		done := func(logr.Logger) (bool, error) { return true, nil }
+		steps := []step{
+			{name: "Validate", reconcile: done},
+			{name: "ServiceAccounts", reconcile: done, after: []string{"Validate"}},
+		}
+		for _, s := range r.steps() {
+			if strings.HasPrefix(s.name, "Console") {
+				steps = append(steps, s)
+			}
+		}
+		if err := r.runSteps(steps); err != nil {
+			t.Fatal(err)
+		}
+
+		exists := func(obj client.Object, name string) bool {
+			err := r.Get(r.Context, types.NamespacedName{Name: name, Namespace: bux.Namespace}, obj)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			return err == nil
+		}
+		for _, gone := range []struct {
+			obj  client.Object
+			name string
+		}{
+			{&appsv1.Deployment{}, names.console()},
+			{&corev1.Service{}, names.console()},
+			{&appsv1.Deployment{}, names.consoleMongo()},
+			{&corev1.Service{}, names.consoleMongodb()},
+			{&corev1.ServiceAccount{}, names.console()},
+			{&corev1.ServiceAccount{}, names.consoleMongo()},
+			{&networkingv1.NetworkPolicy{}, names.consoleMongo()},
+		} {
+			if exists(gone.obj, gone.name) {
+				t.Errorf("%T %s is left after the console was turned off", gone.obj, gone.name)
+			}
+		}
+		if !exists(&networkingv1.Ingress{}, names.console()) {
+			t.Error("the ingress the Bux doesn't own was removed")
+		}
+		kept := exists(&corev1.PersistentVolumeClaim{}, names.consoleMongo())
+		if want := policy != serverv1alpha1.VolumeRetentionPolicyDelete; kept != want {
+			t.Errorf("whenDisabled %q: console mongo volume kept = %t, want %t", policy, kept, want)
+		}
+	}
+}
+
+func TestTurningTheRedisOffRemovesIt(t *testing.T) {
+	for _, operator := range []bool{false, true} {
+		for _, policy := range []serverv1alpha1.VolumeRetentionPolicy{"", serverv1alpha1.VolumeRetentionPolicyDelete} {
+			bux := &serverv1alpha1.Bux{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+				Spec: serverv1alpha1.BuxSpec{
+					Redis: &serverv1alpha1.RedisConfig{
+						URL:     "redis://redis.example.com:6379",
+						Storage: &serverv1alpha1.StorageConfig{WhenDisabled: policy},
+					},
+				},
+			}
+			names := buxNames{instance: "shop"}
+			r := fakeRequest(t, bux)
+			r.RedisOperator = operator
+			// the service account and network policy are named apart from the
+			// redis, they differ with the legacy names
+			owned := []client.Object{
+				&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: names.redis()}},
+				&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: names.redis()}},
+				&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: names.redisServiceAccount()}},
+				&networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: names.redisServiceAccount()}},
+			}
+			if operator {
+				owned = append(owned, &redisv1beta1.Redis{ObjectMeta: metav1.ObjectMeta{Name: names.redis()}})
+			}
+			for _, obj := range owned {
+				obj.SetNamespace(bux.Namespace)
+				if err := controllerutil.SetControllerReference(bux, obj, r.Scheme); err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Create(r.Context, obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the claims of the statefulset pods, nothing owns them
+			claims := []string{"data-shop-redis-0", "shop-redis-shop-redis-0"}
+			for _, name := range claims {
+				pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: bux.Namespace}}
+				if err := r.Create(r.Context, pvc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			done := func(logr.Logger) (bool, error) { return true, nil }
+			steps := []step{
+				{name: "Validate", reconcile: done},
+				{name: "ServiceAccounts", reconcile: done, after: []string{"Validate"}},
+			}
+			for _, s := range r.steps() {
+				if s.name == "Redis" {
+					steps = append(steps, s)
+				}
+			}
+			if err := r.runSteps(steps); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, obj := range owned {
+				err := r.Get(r.Context, client.ObjectKeyFromObject(obj), obj)
+				if !k8serrors.IsNotFound(err) {
+					t.Errorf("operator %t: %T is left after switching to an external redis: %v", operator, obj, err)
+				}
+			}
+			for _, name := range claims {
+				err := r.Get(r.Context, types.NamespacedName{Name: name, Namespace: bux.Namespace},
+					&corev1.PersistentVolumeClaim{})
+				if err != nil && !k8serrors.IsNotFound(err) {
+					t.Fatal(err)
+				}
+				if kept, want := err == nil, policy != serverv1alpha1.VolumeRetentionPolicyDelete; kept != want {
+					t.Errorf("operator %t, whenDisabled %q: %s kept = %t, want %t", operator, policy, name, kept, want)
+				}
+			}
+		}
+	}
+}
+
+func TestBackupAndRestoreServiceAccountsFollowTheirSpec(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Spec: serverv1alpha1.BuxSpec{
+			Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+		},
+	}
+	names := buxNames{instance: "shop"}
+	r := fakeRequest(t, bux)
+	exists := func(name string) bool {
+		err := r.Get(r.Context, types.NamespacedName{Name: name, Namespace: bux.Namespace}, &corev1.ServiceAccount{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	bux.Spec.Backup = &serverv1alpha1.BackupConfig{}
+	bux.Spec.RestoreFrom = &serverv1alpha1.RestoreConfig{}
+	if _, err := r.ReconcileServiceAccounts(r.Log); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{names.backup(), names.restore()} {
+		if !exists(name) {
+			t.Errorf("service account %s is missing", name)
+		}
+	}
+
+	bux.Spec.Backup = nil
+	bux.Spec.RestoreFrom = nil
+	done := func(logr.Logger) (bool, error) { return true, nil }
+	steps := []step{
+		{name: "Validate", reconcile: done},
+		{name: "ServiceAccounts", reconcile: r.ReconcileServiceAccounts, after: []string{"Validate"}},
+		{name: "Datastore", reconcile: done, after: []string{"ServiceAccounts"}},
+	}
+	for _, s := range r.steps() {
+		if s.name == "Backup" || s.name == "Restore" {
+			steps = append(steps, s)
+		}
+	}
+	if err := r.runSteps(steps); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{names.backup(), names.restore()} {
+		if exists(name) {
+			t.Errorf("service account %s is left after it was turned off", name)
+		}
+	}
+	if !exists(names.server()) {
+		t.Error("the service account of bux-server was removed")
+	}
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TestReconcilingBuxesConcurrently reconciles Buxes in parallel with one
+// reconciler, run it with -race
+func TestReconcilingBuxesConcurrently(t *testing.T) {
+	const count = 8
+	keys := make([]types.NamespacedName, count)
+	objs := make([]client.Object, count)
+	for i := range keys {
+		// two tenants, so that Buxes share a namespace
+		keys[i] = types.NamespacedName{Name: fmt.Sprintf("shop-%d", i), Namespace: fmt.Sprintf("tenant-%d", i%2)}
+		objs[i] = &serverv1alpha1.Bux{
+			ObjectMeta: metav1.ObjectMeta{Name: keys[i].Name, Namespace: keys[i].Namespace, UID: types.UID(keys[i].String())},
+			Spec: serverv1alpha1.BuxSpec{
+				Configuration: &serverv1alpha1.BuxConfig{
+					Paymail:   &serverv1alpha1.PaymailConfig{Enabled: true},
+					Datastore: "postgresql",
+				},
+				Domain:  "example.com",
+				Console: i%2 == 0,
+			},
+		}
+	}
+	c := fakeClient(t, objs...)
+	r := &BuxReconciler{Client: c, APIReader: c, Scheme: c.Scheme(), MaxConcurrentReconciles: count}
+
+	errs := make([]error, count)
+	wg := sync.WaitGroup{}
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: keys[i]})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if errs[i] != nil {
+			t.Errorf("%s: %v", key, errs[i])
+			continue
+		}
+		bux := serverv1alpha1.Bux{}
+		if err := c.Get(context.Background(), key, &bux); err != nil {
+			t.Fatal(err)
+		}
+		if !apimeta.IsStatusConditionTrue(bux.Status.Conditions, serverv1alpha1.ConditionReconciled) {
+			t.Errorf("%s was not reconciled: %v", key, bux.Status.Conditions)
+		}
+		// the deployment is named after its own Bux, not another one
+		dep := appsv1.Deployment{}
+		if err := c.Get(context.Background(), key, &dep); err != nil {
+			t.Errorf("%s: %v", key, err)
+		} else if !metav1.IsControlledBy(&dep, &bux) {
+			t.Errorf("deployment %s is controlled by %v", key, dep.OwnerReferences)
+		}
+	}
+}
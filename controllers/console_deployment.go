@@ -7,46 +7,30 @@ import (
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-// ReconcileConsole will reconcile console
-func (r *BuxReconciler) ReconcileConsole(log logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
-	if !bux.Spec.Console {
-		return false, nil
-	}
-	return ReconcileBatch(log,
-		r.ReconcileConsoleDeployment,
-		r.ReconcileConsoleService,
-		r.ReconcileConsoleMongoDeployment,
-		r.ReconcileConsoleMongoService,
-		r.ReconcileConsoleMongoPVC,
-		r.ReconcileConsoleIngress,
-	)
-
-}
-
 // ReconcileConsoleDeployment is the deployment
-func (r *BuxReconciler) ReconcileConsoleDeployment(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileConsoleDeployment(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	dep := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-console",
+			Name:      r.Names.console(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentConsole),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &dep, func() error {
-		return r.updateConsoleDeployment(&dep, &bux)
+	// Selectors are immutable, wait for the deployment with the old one to be replaced
+	old := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: dep.Name, Namespace: dep.Namespace}}
+	if migrated, err := r.migrateSelector(bux, old, r.Names.selector(componentConsole)); !migrated || err != nil {
+		return false, err
+	}
+	err := r.apply(&dep, func() error {
+		return r.updateConsoleDeployment(&dep, bux)
 	})
 	if err != nil {
 		return false, err
@@ -54,21 +38,19 @@ func (r *BuxReconciler) ReconcileConsoleDeployment(_ logr.Logger) (bool, error)
 	return true, nil
 }
 
-func (r *BuxReconciler) updateConsoleDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateConsoleDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, dep, r.Scheme)
 	if err != nil {
 		return err
 	}
-	url := fmt.Sprintf("https://%s-console.%s", bux.Namespace, bux.Spec.Domain)
-	dep.Spec = *defaultConsoleDeploymentSpec(url)
-	return nil
+	url := "https://" + r.Names.consoleHost(bux)
+	dep.Spec = *defaultConsoleDeploymentSpec(r.Names, url)
+	useRegistry(&dep.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return applyPodConfig(&dep.Spec.Template, consolePodConfig(bux))
 }
 
-func defaultConsoleDeploymentSpec(url string) *appsv1.DeploymentSpec {
-	podLabels := map[string]string{
-		"app":        "bux-console",
-		"deployment": "bux-console",
-	}
+func defaultConsoleDeploymentSpec(names buxNames, url string) *appsv1.DeploymentSpec {
+	podLabels := names.labels(componentConsole)
 	var envFrom []corev1.EnvFromSource
 	envVars := []corev1.EnvVar{
 		{
@@ -81,19 +63,27 @@ func defaultConsoleDeploymentSpec(url string) *appsv1.DeploymentSpec {
 		},
 		{
 			Name:  "MONGO_URL",
-			Value: "mondogb://bux-console-mongodb:27017/meteor",
+			Value: fmt.Sprintf("mondogb://%s:27017/meteor", names.consoleMongodb()),
 		},
 	}
 	image := "docker.io/galtbv/bux-console:latest"
+	listening := corev1.ProbeHandler{
+		TCPSocket: &corev1.TCPSocketAction{
+			Port: intstr.FromInt(3000),
+		},
+	}
 	return &appsv1.DeploymentSpec{
 		Replicas: pointer.Int32Ptr(1),
-		Selector: metav1.SetAsLabelSelector(podLabels),
+		Selector: metav1.SetAsLabelSelector(names.selector(componentConsole)),
 		Template: corev1.PodTemplateSpec{
 			ObjectMeta: metav1.ObjectMeta{
 				CreationTimestamp: metav1.Time{},
 				Labels:            podLabels,
 			},
 			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(defaultUID),
+				ServiceAccountName:           names.console(),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
 				Containers: []corev1.Container{
 					{
 						EnvFrom:                  envFrom,
@@ -102,6 +92,30 @@ func defaultConsoleDeploymentSpec(url string) *appsv1.DeploymentSpec {
 						ImagePullPolicy:          corev1.PullAlways,
 						Name:                     "bux-console",
 						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("1Gi"),
+							},
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("50m"),
+								corev1.ResourceMemory: resource.MustParse("256Mi"),
+							},
+						},
+						StartupProbe: &corev1.Probe{
+							ProbeHandler:     listening,
+							PeriodSeconds:    5,
+							FailureThreshold: 60,
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:  listening,
+							PeriodSeconds: 10,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:     listening,
+							PeriodSeconds:    10,
+							FailureThreshold: 6,
+						},
 						Ports: []corev1.ContainerPort{
 							{
 								ContainerPort: 3000,
@@ -1,35 +1,34 @@
 package controllers
 
 import (
-	"fmt"
-
 	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // ReconcileConsoleMongoDeployment is the deployment
-func (r *BuxReconciler) ReconcileConsoleMongoDeployment(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
-	if !bux.Spec.Console {
-		return false, nil
-	}
+func (r *BuxRequest) ReconcileConsoleMongoDeployment(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	dep := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-console-mongo",
+			Name:      r.Names.consoleMongo(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentConsoleMongo),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &dep, func() error {
-		return r.updateConsoleMongoDeployment(&dep, &bux)
+	// Selectors are immutable, wait for the deployment with the old one to be replaced
+	old := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: dep.Name, Namespace: dep.Namespace}}
+	if migrated, err := r.migrateSelector(bux, old, r.Names.selector(componentConsoleMongo)); !migrated || err != nil {
+		return false, err
+	}
+	err := r.apply(&dep, func() error {
+		return r.updateConsoleMongoDeployment(&dep, bux)
 	})
 	if err != nil {
 		return false, err
@@ -37,31 +36,36 @@ func (r *BuxReconciler) ReconcileConsoleMongoDeployment(_ logr.Logger) (bool, er
 	return true, nil
 }
 
-func (r *BuxReconciler) updateConsoleMongoDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateConsoleMongoDeployment(dep *appsv1.Deployment, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, dep, r.Scheme)
 	if err != nil {
 		return err
 	}
-	url := fmt.Sprintf("https://%s-console.%s", bux.Namespace, bux.Spec.Domain)
-	dep.Spec = *defaultConsoleMongoDeploymentSpec(url)
-	return nil
+	dep.Spec = *defaultConsoleMongoDeploymentSpec(r.Names)
+	useRegistry(&dep.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return applyPodConfig(&dep.Spec.Template, consoleMongoPodConfig(bux))
 }
 
-func defaultConsoleMongoDeploymentSpec(_ string) *appsv1.DeploymentSpec {
-	podLabels := map[string]string{
-		"app":        "bux-console-mongo",
-		"deployment": "bux-console-mongo",
-	}
+func defaultConsoleMongoDeploymentSpec(names buxNames) *appsv1.DeploymentSpec {
+	podLabels := names.labels(componentConsoleMongo)
 	image := "docker.io/mongo:latest"
+	listening := corev1.ProbeHandler{
+		TCPSocket: &corev1.TCPSocketAction{
+			Port: intstr.FromInt(27017),
+		},
+	}
 	return &appsv1.DeploymentSpec{
 		Replicas: pointer.Int32Ptr(1),
-		Selector: metav1.SetAsLabelSelector(podLabels),
+		Selector: metav1.SetAsLabelSelector(names.selector(componentConsoleMongo)),
 		Template: corev1.PodTemplateSpec{
 			ObjectMeta: metav1.ObjectMeta{
 				CreationTimestamp: metav1.Time{},
 				Labels:            podLabels,
 			},
 			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(mongoUID),
+				ServiceAccountName:           names.consoleMongo(),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
 				Containers: []corev1.Container{
 					{
 						Args: []string{
@@ -71,6 +75,27 @@ func defaultConsoleMongoDeploymentSpec(_ string) *appsv1.DeploymentSpec {
 						ImagePullPolicy:          corev1.PullAlways,
 						Name:                     "bux-console-mongo",
 						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("1Gi"),
+							},
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("100m"),
+								corev1.ResourceMemory: resource.MustParse("256Mi"),
+							},
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:        listening,
+							InitialDelaySeconds: 5,
+							PeriodSeconds:       10,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:        listening,
+							InitialDelaySeconds: 30,
+							PeriodSeconds:       10,
+							FailureThreshold:    6,
+						},
 						VolumeMounts: []corev1.VolumeMount{
 							{
 								Name:      "data",
@@ -90,7 +115,7 @@ func defaultConsoleMongoDeploymentSpec(_ string) *appsv1.DeploymentSpec {
 						Name: "data",
 						VolumeSource: corev1.VolumeSource{
 							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-								ClaimName: "bux-console-mongo",
+								ClaimName: names.consoleMongo(),
 							},
 						},
 					},
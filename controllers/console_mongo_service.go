@@ -10,19 +10,17 @@ import (
 )
 
 // ReconcileConsoleMongoService is the service
-func (r *BuxReconciler) ReconcileConsoleMongoService(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileConsoleMongoService(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	svc := corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-console-mongodb",
+			Name:      r.Names.consoleMongodb(),
 			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentConsoleMongo),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &svc, func() error {
-		return r.updateConsoleMongodbService(&svc, &bux)
+	err := r.apply(&svc, func() error {
+		return r.updateConsoleMongodbService(&svc, bux)
 	})
 	if err != nil {
 		return false, err
@@ -30,19 +28,17 @@ func (r *BuxReconciler) ReconcileConsoleMongoService(_ logr.Logger) (bool, error
 	return true, nil
 }
 
-func (r *BuxReconciler) updateConsoleMongodbService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateConsoleMongodbService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, svc, r.Scheme)
 	if err != nil {
 		return err
 	}
-	svc.Spec = *defaultConsoleMongodbServiceSpec()
+	svc.Spec = *defaultConsoleMongodbServiceSpec(r.Names)
 	return nil
 }
 
-func defaultConsoleMongodbServiceSpec() *corev1.ServiceSpec {
-	labels := map[string]string{
-		"app": "bux-console-mongodb",
-	}
+func defaultConsoleMongodbServiceSpec(names buxNames) *corev1.ServiceSpec {
+	labels := names.selector(componentConsoleMongo)
 	return &corev1.ServiceSpec{
 		Selector: labels,
 		Type:     corev1.ServiceTypeClusterIP,
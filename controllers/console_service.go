@@ -1,8 +1,6 @@
 package controllers
 
 import (
-	"fmt"
-
 	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -13,24 +11,17 @@ import (
 )
 
 // ReconcileConsoleIngress is the ingress
-func (r *BuxReconciler) ReconcileConsoleIngress(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
-	// Skip if domain isn't set
-	if bux.Spec.Domain == "" {
-		return false, nil
-	}
+func (r *BuxRequest) ReconcileConsoleIngress(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	ingress := networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-console",
+			Name:      r.Names.console(),
 			Namespace: r.NamespacedName.Namespace,
-			Labels:    r.getAppLabels(),
+			Labels:    r.getAppLabels(componentConsole),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &ingress, func() error {
-		return r.updateConsoleIngress(&ingress, &bux)
+	err := r.apply(&ingress, func() error {
+		return r.updateConsoleIngress(&ingress, bux)
 	})
 	if err != nil {
 		return false, err
@@ -39,19 +30,17 @@ func (r *BuxReconciler) ReconcileConsoleIngress(_ logr.Logger) (bool, error) {
 }
 
 // ReconcileConsoleService is the service
-func (r *BuxReconciler) ReconcileConsoleService(_ logr.Logger) (bool, error) {
-	bux := serverv1alpha1.Bux{}
-	if err := r.Get(r.Context, r.NamespacedName, &bux); err != nil {
-		return false, err
-	}
+func (r *BuxRequest) ReconcileConsoleService(_ logr.Logger) (bool, error) {
+	bux := r.Bux
 	svc := corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "bux-console",
+			Name:      r.Names.console(),
 			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentConsole),
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(r.Context, r.Client, &svc, func() error {
-		return r.updateConsoleService(&svc, &bux)
+	err := r.apply(&svc, func() error {
+		return r.updateConsoleService(&svc, bux)
 	})
 	if err != nil {
 		return false, err
@@ -59,7 +48,7 @@ func (r *BuxReconciler) ReconcileConsoleService(_ logr.Logger) (bool, error) {
 	return true, nil
 }
 
-func (r *BuxReconciler) updateConsoleIngress(ingress *networkingv1.Ingress, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateConsoleIngress(ingress *networkingv1.Ingress, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, ingress, r.Scheme)
 	if err != nil {
 		return err
@@ -68,35 +57,35 @@ func (r *BuxReconciler) updateConsoleIngress(ingress *networkingv1.Ingress, bux
 		if ingress.Annotations == nil {
 			ingress.Annotations = make(map[string]string)
 		}
-		ingress.Annotations["cert-manager.io/cluster-issuer"] = bux.Spec.ClusterIssuer
+		ingress.Annotations[clusterIssuerAnnotation] = bux.Spec.ClusterIssuer
 	}
-	ingress.Spec = *defaultConsoleIngressSpec(bux)
+	ingress.Spec = *defaultConsoleIngressSpec(r.Names, bux)
 	return nil
 }
 
-func (r *BuxReconciler) updateConsoleService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
+func (r *BuxRequest) updateConsoleService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
 	err := controllerutil.SetControllerReference(bux, svc, r.Scheme)
 	if err != nil {
 		return err
 	}
-	svc.Spec = *defaultConsoleServiceSpec()
+	svc.Spec = *defaultConsoleServiceSpec(r.Names)
 	return nil
 }
 
-func defaultConsoleIngressSpec(bux *serverv1alpha1.Bux) *networkingv1.IngressSpec {
+func defaultConsoleIngressSpec(names buxNames, bux *serverv1alpha1.Bux) *networkingv1.IngressSpec {
 	pathType := networkingv1.PathTypeImplementationSpecific
 	return &networkingv1.IngressSpec{
 		TLS: []networkingv1.IngressTLS{
 			{
 				Hosts: []string{
-					fmt.Sprintf("%s-console.%s", bux.Namespace, bux.Spec.Domain),
+					names.consoleHost(bux),
 				},
-				SecretName: "bux-console-tls",
+				SecretName: names.child("console-tls"),
 			},
 		},
 		Rules: []networkingv1.IngressRule{
 			{
-				Host: fmt.Sprintf("%s-console.%s", bux.Namespace, bux.Spec.Domain),
+				Host: names.consoleHost(bux),
 				IngressRuleValue: networkingv1.IngressRuleValue{
 					HTTP: &networkingv1.HTTPIngressRuleValue{
 						Paths: []networkingv1.HTTPIngressPath{
@@ -104,7 +93,7 @@ func defaultConsoleIngressSpec(bux *serverv1alpha1.Bux) *networkingv1.IngressSpe
 								PathType: &pathType,
 								Backend: networkingv1.IngressBackend{
 									Service: &networkingv1.IngressServiceBackend{
-										Name: "bux-console",
+										Name: names.console(),
 										Port: networkingv1.ServiceBackendPort{
 											Number: int32(3000),
 										},
@@ -119,10 +108,8 @@ func defaultConsoleIngressSpec(bux *serverv1alpha1.Bux) *networkingv1.IngressSpe
 	}
 }
 
-func defaultConsoleServiceSpec() *corev1.ServiceSpec {
-	labels := map[string]string{
-		"app": "bux-console",
-	}
+func defaultConsoleServiceSpec(names buxNames) *corev1.ServiceSpec {
+	labels := names.selector(componentConsole)
 	return &corev1.ServiceSpec{
 		Selector: labels,
 		Type:     corev1.ServiceTypeClusterIP,
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The recommended labels, https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	nameLabel      = "app.kubernetes.io/name"
+	componentLabel = "app.kubernetes.io/component"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	partOfLabel    = "app.kubernetes.io/part-of"
+
+	managedBy = "bux-kube-controller"
+	partOf    = "bux"
+)
+
+// component is a part of a Bux, its pods are selected by the instance and
+// component labels
+type component struct {
+	// name is the application the component runs
+	name string
+	// component is the role of the component within the Bux
+	component string
+}
+
+var (
+	componentServer       = component{name: "bux-server", component: "server"}
+	componentMigration    = component{name: "bux-server", component: "migration"}
+	componentConsole      = component{name: "bux-console", component: "console"}
+	componentConsoleMongo = component{name: "mongodb", component: "console-datastore"}
+	componentDatastore    = component{name: "postgresql", component: "datastore"}
+	componentCache        = component{name: "redis", component: "cache"}
+	componentBackup       = component{name: "bux-backup", component: "backup"}
+	componentRestore      = component{name: "bux-restore", component: "restore"}
+	componentMaintenance  = component{name: "nginx", component: "maintenance"}
+)
+
+// selector selects the pods of component, and nothing else
+func (n buxNames) selector(c component) map[string]string {
+	return map[string]string{
+		serverv1alpha1.InstanceLabel: n.instance,
+		componentLabel:               c.component,
+	}
+}
+
+// labels are the labels of the objects and pods of component
+func (n buxNames) labels(c component) map[string]string {
+	labels := n.selector(c)
+	labels[nameLabel] = c.name
+	labels[managedByLabel] = managedBy
+	labels[partOfLabel] = partOf
+	return labels
+}
+
+// migrateSelector makes way for a workload with a new selector, selectors are
+// immutable. The pods, and the replica sets of a deployment, get the new labels
+// and are orphaned, so the workload that replaces it adopts them and rolls them
+// over to its template without downtime. It returns true once the workload has
+// selector or is gone.
+func (r *BuxRequest) migrateSelector(bux *serverv1alpha1.Bux, workload client.Object,
+	selector map[string]string,
+) (bool, error) {
+	if err := r.Get(r.Context, client.ObjectKeyFromObject(workload), workload); err != nil {
+		return k8serrors.IsNotFound(err), client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(workload, bux) {
+		return true, nil
+	}
+	if workload.GetDeletionTimestamp() != nil {
+		return false, nil
+	}
+	var current *metav1.LabelSelector
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		current = w.Spec.Selector
+	case *appsv1.StatefulSet:
+		current = w.Spec.Selector
+	}
+	if current == nil || apiequality.Semantic.DeepEqual(current, metav1.SetAsLabelSelector(selector)) {
+		return true, nil
+	}
+
+	inNamespace := client.InNamespace(workload.GetNamespace())
+	selected := client.MatchingLabels(current.MatchLabels)
+	replicaSets := appsv1.ReplicaSetList{}
+	if err := r.APIReader.List(r.Context, &replicaSets, inNamespace, selected); err != nil {
+		return false, err
+	}
+	for i := range replicaSets.Items {
+		if !metav1.IsControlledBy(&replicaSets.Items[i], workload) {
+			continue
+		}
+		if err := r.addLabels(&replicaSets.Items[i], selector); err != nil {
+			return false, err
+		}
+	}
+	pods := corev1.PodList{}
+	if err := r.APIReader.List(r.Context, &pods, inNamespace, selected); err != nil {
+		return false, err
+	}
+	for i := range pods.Items {
+		if err := r.addLabels(&pods.Items[i], selector); err != nil {
+			return false, err
+		}
+	}
+	err := r.Delete(r.Context, workload, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	return false, client.IgnoreNotFound(err)
+}
+
+// addLabels patches labels onto obj
+func (r *BuxRequest) addLabels(obj client.Object, labels map[string]string) error {
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	existing := obj.GetLabels()
+	if existing == nil {
+		existing = make(map[string]string, len(labels))
+	}
+	for k, v := range labels {
+		existing[k] = v
+	}
+	obj.SetLabels(existing)
+	return client.IgnoreNotFound(r.Patch(r.Context, obj, patch))
+}
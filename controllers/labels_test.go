@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestServicesSelectOnlyTheirComponent(t *testing.T) {
+	names := buxNames{instance: "bux"}
+	other := buxNames{instance: "shop"}
+	postgresql := &serverv1alpha1.Bux{
+		Spec: serverv1alpha1.BuxSpec{
+			Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+			Backup: &serverv1alpha1.BackupConfig{
+				S3: &serverv1alpha1.S3Config{Bucket: "bux", CredentialsSecret: "bux-backup"},
+			},
+		},
+	}
+
+	pods := map[string]*corev1.PodTemplateSpec{
+		"bux":                &defaultDeploymentSpec(names, latestVersion, nil).Template,
+		"bux-console":        &defaultConsoleDeploymentSpec(names, "").Template,
+		"bux-console-mongo":  &defaultConsoleMongoDeploymentSpec(names).Template,
+		"bux-postgresql":     &defaultPostgresqlStatefulSetSpec(names).Template,
+		"bux-backup":         &defaultBackupCronJobSpec(names, postgresql).JobTemplate.Spec.Template,
+		"bux-migrate":        &defaultMigrationJobSpec(names, buxImage(latestVersion)).Template,
+		"bux-redis":          &defaultRedisStatefulSetSpec(names, nil).Template,
+		"bux-maintenance":    &defaultMaintenanceDeploymentSpec(names).Template,
+		"shop":               &defaultDeploymentSpec(other, latestVersion, nil).Template,
+		"shop-postgresql":    &defaultPostgresqlStatefulSetSpec(other).Template,
+		"shop-console":       &defaultConsoleDeploymentSpec(other, "").Template,
+		"shop-console-mongo": &defaultConsoleMongoDeploymentSpec(other).Template,
+		"shop-redis":         &defaultRedisStatefulSetSpec(other, nil).Template,
+		"shop-migrate":       &defaultMigrationJobSpec(other, buxImage(latestVersion)).Template,
+		"shop-backup":        &defaultBackupCronJobSpec(other, postgresql).JobTemplate.Spec.Template,
+	}
+	services := map[string]struct {
+		spec *corev1.ServiceSpec
+		pods string
+	}{
+		"bux":                 {defaultServiceSpec(names), "bux"},
+		"bux-datastore":       {defaultDatastoreServiceSpec(names), "bux-postgresql"},
+		"bux-console":         {defaultConsoleServiceSpec(names), "bux-console"},
+		"bux-console-mongodb": {defaultConsoleMongodbServiceSpec(names), "bux-console-mongo"},
+		"bux-redis":           {defaultRedisServiceSpec(names), "bux-redis"},
+		"bux-maintenance":     {defaultMaintenanceServiceSpec(names), "bux-maintenance"},
+	}
+	for name, service := range services {
+		selector := labels.SelectorFromSet(service.spec.Selector)
+		for podName, pod := range pods {
+			matches := selector.Matches(labels.Set(pod.Labels))
+			if matches != (podName == service.pods) {
+				t.Errorf("service %s selects %s pods: %t", name, podName, matches)
+			}
+		}
+	}
+}
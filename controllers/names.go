@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"fmt"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// legacyPrefix is what the objects of every Bux were named after before the
+// names were derived from the Bux
+const legacyPrefix = "bux"
+
+// maxInstanceNameLength leaves room within a 63 character name for the longest
+// suffix, that of the pre-upgrade backup job with its hash
+const maxInstanceNameLength = 63 - len("-pre-upgrade-") - 10
+
+// buxNames are the names of the objects of a Bux, so that several Buxes can
+// share a namespace.
+type buxNames struct {
+	// instance is the name of the Bux
+	instance string
+	// legacy Buxes keep the fixed names they were created with
+	legacy bool
+}
+
+// newBuxNames are the names of the objects of bux
+func newBuxNames(bux *serverv1alpha1.Bux) buxNames {
+	return buxNames{
+		instance: bux.Name,
+		legacy:   bux.Status.Naming == serverv1alpha1.NamingLegacy,
+	}
+}
+
+func (n buxNames) prefix() string {
+	if n.legacy {
+		return legacyPrefix
+	}
+	return n.instance
+}
+
+func (n buxNames) child(suffix string) string {
+	return n.prefix() + "-" + suffix
+}
+
+// server is bux-server, its deployment, service, ingress and service account
+func (n buxNames) server() string { return n.prefix() }
+
+func (n buxNames) config() string { return n.child("config") }
+
+func (n buxNames) postgresql() string { return n.child("postgresql") }
+
+// datastore is the service of postgresql, and the host bux-server connects to
+func (n buxNames) datastore() string { return n.child("datastore") }
+
+// redis is the redis of bux-server, the redis operator labels its pods with it
+func (n buxNames) redis() string {
+	if n.legacy {
+		return "redis-standalone"
+	}
+	return n.child("redis")
+}
+
+// redisServiceAccount is apart from redis since the legacy names differ
+func (n buxNames) redisServiceAccount() string { return n.child("redis") }
+
+func (n buxNames) console() string { return n.child("console") }
+
+func (n buxNames) consoleMongo() string { return n.child("console-mongo") }
+
+// consoleMongodb is the service of the console mongodb
+func (n buxNames) consoleMongodb() string { return n.child("console-mongodb") }
+
+func (n buxNames) backup() string { return n.child("backup") }
+
+func (n buxNames) restore() string { return n.child("restore") }
+
+// migrate is the deployment label of the migration jobs
+func (n buxNames) migrate() string { return n.child("migrate") }
+
+func (n buxNames) preUpgrade() string { return n.child("pre-upgrade") }
+
+// maintenance serves the maintenance response while bux-server is down
+func (n buxNames) maintenance() string { return n.child("maintenance") }
+
+// host is where the ingress serves bux-server, and its paymail domain
+func (n buxNames) host(bux *serverv1alpha1.Bux) string {
+	if n.legacy {
+		return fmt.Sprintf("%s.%s", bux.Namespace, bux.Spec.Domain)
+	}
+	return fmt.Sprintf("%s-%s.%s", n.instance, bux.Namespace, bux.Spec.Domain)
+}
+
+// consoleHost is where the ingress serves the console
+func (n buxNames) consoleHost(bux *serverv1alpha1.Bux) string {
+	if n.legacy {
+		return fmt.Sprintf("%s-console.%s", bux.Namespace, bux.Spec.Domain)
+	}
+	return fmt.Sprintf("%s-%s-console.%s", n.instance, bux.Namespace, bux.Spec.Domain)
+}
+
+// resolveNaming decides how the names of the Bux are derived the first time it
+// is reconciled, and records it in the NamingAnnotation so that it outlives
+// the status. A Bux whose datastore volume has the fixed name of an older
+// controller keeps the fixed names. A paused Bux is not written, its naming is
+// recorded once it resumes.
+func (r *BuxRequest) resolveNaming(bux *serverv1alpha1.Bux) error {
+	naming := serverv1alpha1.Naming(bux.Annotations[serverv1alpha1.NamingAnnotation])
+	if naming != serverv1alpha1.NamingInstance && naming != serverv1alpha1.NamingLegacy {
+		naming = bux.Status.Naming
+	}
+	if naming == "" {
+		legacy, err := r.hasLegacyVolume(bux)
+		if err != nil {
+			return err
+		}
+		naming = serverv1alpha1.NamingInstance
+		if legacy {
+			naming = serverv1alpha1.NamingLegacy
+		}
+	}
+	if !bux.Spec.Paused && bux.Annotations[serverv1alpha1.NamingAnnotation] != string(naming) {
+		patch := client.MergeFrom(bux.DeepCopy())
+		metav1.SetMetaDataAnnotation(&bux.ObjectMeta, serverv1alpha1.NamingAnnotation, string(naming))
+		if err := r.Patch(r.Context, bux, patch); err != nil {
+			return err
+		}
+	}
+	bux.Status.Naming = naming
+	return nil
+}
+
+// hasLegacyVolume returns true if the postgresql volume of an older controller
+// is the Bux's: it controls it, or no other Bux controls the volume and it names
+// no other Bux as its instance. The volumes of older controllers have a
+// controller reference but no instance label. A Bux named bux has that volume
+// either way, and is taken to be legacy.
+func (r *BuxRequest) hasLegacyVolume(bux *serverv1alpha1.Bux) (bool, error) {
+	legacy := buxNames{instance: bux.Name, legacy: true}
+	pvc := corev1.PersistentVolumeClaim{}
+	key := types.NamespacedName{Name: legacy.postgresql(), Namespace: bux.Namespace}
+	if err := r.Get(r.Context, key, &pvc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if metav1.IsControlledBy(&pvc, bux) {
+		return true, nil
+	}
+	if metav1.GetControllerOf(&pvc) != nil {
+		return false, nil
+	}
+	instance := pvc.Labels[serverv1alpha1.InstanceLabel]
+	return instance == "" || instance == bux.Name, nil
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestBuxNames(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments"},
+		Spec:       serverv1alpha1.BuxSpec{Domain: "example.com"},
+	}
+	names := newBuxNames(bux)
+	if got := names.postgresql(); got != "shop-postgresql" {
+		t.Errorf("postgresql = %s, want shop-postgresql", got)
+	}
+	if got := names.redis(); got != "shop-redis" {
+		t.Errorf("redis = %s, want shop-redis", got)
+	}
+	if got := names.host(bux); got != "shop-payments.example.com" {
+		t.Errorf("host = %s, want shop-payments.example.com", got)
+	}
+
+	// Buxes of older controllers keep the names their volumes were created with
+	bux.Status.Naming = serverv1alpha1.NamingLegacy
+	legacy := newBuxNames(bux)
+	if got := legacy.postgresql(); got != "bux-postgresql" {
+		t.Errorf("legacy postgresql = %s, want bux-postgresql", got)
+	}
+	if got := legacy.redis(); got != "redis-standalone" {
+		t.Errorf("legacy redis = %s, want redis-standalone", got)
+	}
+	if got := legacy.host(bux); got != "payments.example.com" {
+		t.Errorf("legacy host = %s, want payments.example.com", got)
+	}
+	if got := legacy.selector(componentServer)[serverv1alpha1.InstanceLabel]; got != "shop" {
+		t.Errorf("legacy instance label = %s, want shop", got)
+	}
+}
+
+func TestNamingOutlivesTheStatus(t *testing.T) {
+	legacyVolume := func(labels map[string]string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: "bux-postgresql", Namespace: "payments", Labels: labels,
+		}}
+	}
+	// the volume of the Bux named bux, created by an older controller, in the
+	// namespace that shop joins
+	other := &serverv1alpha1.Bux{ObjectMeta: metav1.ObjectMeta{Name: "bux", Namespace: "payments", UID: "bux"}}
+	otherVolume := legacyVolume(nil)
+	otherVolume.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(other,
+		serverv1alpha1.GroupVersion.WithKind("Bux"))}
+	for _, test := range []struct {
+		name       string
+		annotation string
+		objs       []client.Object
+		want       serverv1alpha1.Naming
+	}{
+		{name: "new", want: serverv1alpha1.NamingInstance},
+		{name: "annotated legacy", annotation: "Legacy", want: serverv1alpha1.NamingLegacy},
+		{name: "annotated instance", annotation: "Instance",
+			objs: []client.Object{legacyVolume(nil)}, want: serverv1alpha1.NamingInstance},
+		{name: "restored legacy", objs: []client.Object{
+			legacyVolume(map[string]string{serverv1alpha1.InstanceLabel: "shop"}),
+		}, want: serverv1alpha1.NamingLegacy},
+		{name: "older controller", objs: []client.Object{legacyVolume(nil)}, want: serverv1alpha1.NamingLegacy},
+		{name: "volume of another Bux", objs: []client.Object{
+			legacyVolume(map[string]string{serverv1alpha1.InstanceLabel: "bux"}),
+		}, want: serverv1alpha1.NamingInstance},
+		{name: "volume controlled by another Bux", objs: []client.Object{other, otherVolume},
+			want: serverv1alpha1.NamingInstance},
+	} {
+		bux := &serverv1alpha1.Bux{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"}}
+		if test.annotation != "" {
+			bux.Annotations = map[string]string{serverv1alpha1.NamingAnnotation: test.annotation}
+		}
+		r := fakeRequest(t, bux, append(test.objs, bux)...)
+		if err := r.resolveNaming(bux); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if bux.Status.Naming != test.want {
+			t.Errorf("%s: naming = %s, want %s", test.name, bux.Status.Naming, test.want)
+		}
+		stored := serverv1alpha1.Bux{}
+		if err := r.Get(r.Context, r.NamespacedName, &stored); err != nil {
+			t.Fatal(err)
+		}
+		if got := stored.Annotations[serverv1alpha1.NamingAnnotation]; got != string(test.want) {
+			t.Errorf("%s: annotation = %q, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// writeRecorder records the objects written through it, the status writes
+// aren't recorded
+type writeRecorder struct {
+	client.Client
+	writes []string
+}
+
+func (c *writeRecorder) record(verb string, obj client.Object) {
+	c.writes = append(c.writes, fmt.Sprintf("%s %T %s", verb, obj, obj.GetName()))
+}
+
+func (c *writeRecorder) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.record("create", obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *writeRecorder) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.record("update", obj)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *writeRecorder) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.PatchOption,
+) error {
+	c.record("patch", obj)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *writeRecorder) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.record("delete", obj)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func TestPausedBuxIsNotWritten(t *testing.T) {
+	bux := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"},
+		Spec: serverv1alpha1.BuxSpec{
+			Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+			Paused:        true,
+		},
+	}
+	volume := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: "bux-postgresql", Namespace: bux.Namespace,
+	}}
+	r := fakeRequest(t, bux, bux, volume)
+	recorder := &writeRecorder{Client: r.Client}
+	r.Client = recorder
+	r.BuxStatus = &bux.Status
+
+	if _, err := r.reconcile(bux); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.writes) > 0 {
+		t.Errorf("the paused Bux wrote %v", recorder.writes)
+	}
+	stored := serverv1alpha1.Bux{}
+	if err := r.Get(r.Context, r.NamespacedName, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Annotations[serverv1alpha1.NamingAnnotation]; ok {
+		t.Errorf("the paused Bux was annotated: %v", stored.Annotations)
+	}
+	// the names are still resolved, for the status and the steps that look
+	if r.Names != (buxNames{instance: bux.Name, legacy: true}) || stored.Status.Naming != serverv1alpha1.NamingLegacy {
+		t.Errorf("names = %+v, status.naming = %s", r.Names, stored.Status.Naming)
+	}
+	condition := apimeta.FindStatusCondition(stored.Status.Conditions, serverv1alpha1.ConditionReconciled)
+	if condition == nil || condition.Reason != serverv1alpha1.ReconciledReasonPaused {
+		t.Errorf("reconciled condition is %v", condition)
+	}
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:rbac:groups=server.getbux.io,resources=buxplatforms,verbs=get;list;watch
+
+// getPlatform is the spec of the BuxPlatform, nil when there is none
+func (r *BuxRequest) getPlatform() (*serverv1alpha1.BuxPlatformSpec, error) {
+	platform := serverv1alpha1.BuxPlatform{}
+	err := r.Get(r.Context, types.NamespacedName{Name: serverv1alpha1.BuxPlatformName}, &platform)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &platform.Spec, nil
+}
+
+// applyPlatformDefaults sets the fields of spec that are empty to those of
+// platform
+func applyPlatformDefaults(spec *serverv1alpha1.BuxSpec, platform *serverv1alpha1.BuxPlatformSpec) {
+	if platform == nil {
+		return
+	}
+	if spec.Domain == "" {
+		spec.Domain = platform.Domain
+	}
+	if spec.ClusterIssuer == "" {
+		spec.ClusterIssuer = platform.ClusterIssuer
+	}
+	if spec.ImageRegistry == "" {
+		spec.ImageRegistry = platform.ImageRegistry
+	}
+	if platform.StorageClassName != nil {
+		if spec.Postgresql == nil {
+			spec.Postgresql = &serverv1alpha1.PostgresqlConfig{}
+		}
+		spec.Postgresql.Storage = withStorageClass(spec.Postgresql.Storage, platform.StorageClassName)
+		if spec.ConsoleMongo == nil {
+			spec.ConsoleMongo = &serverv1alpha1.ConsoleMongoConfig{}
+		}
+		spec.ConsoleMongo.Storage = withStorageClass(spec.ConsoleMongo.Storage, platform.StorageClassName)
+		if spec.Redis == nil {
+			spec.Redis = &serverv1alpha1.RedisConfig{}
+		}
+		spec.Redis.Storage = withStorageClass(spec.Redis.Storage, platform.StorageClassName)
+	}
+	if presets := platform.Resources; presets != nil {
+		if presets.Server != nil {
+			if spec.Server == nil {
+				spec.Server = &serverv1alpha1.ServerConfig{}
+			}
+			spec.Server.Resources = withResources(spec.Server.Resources, presets.Server)
+		}
+		if presets.ConsoleApp != nil {
+			if spec.ConsoleApp == nil {
+				spec.ConsoleApp = &serverv1alpha1.ConsoleAppConfig{}
+			}
+			spec.ConsoleApp.Resources = withResources(spec.ConsoleApp.Resources, presets.ConsoleApp)
+		}
+		if presets.Postgresql != nil {
+			if spec.Postgresql == nil {
+				spec.Postgresql = &serverv1alpha1.PostgresqlConfig{}
+			}
+			spec.Postgresql.Resources = withResources(spec.Postgresql.Resources, presets.Postgresql)
+		}
+		if presets.ConsoleMongo != nil {
+			if spec.ConsoleMongo == nil {
+				spec.ConsoleMongo = &serverv1alpha1.ConsoleMongoConfig{}
+			}
+			spec.ConsoleMongo.Resources = withResources(spec.ConsoleMongo.Resources, presets.ConsoleMongo)
+		}
+	}
+}
+
+// withStorageClass is storage with the storage class it sets, or else
+// storageClassName
+func withStorageClass(storage *serverv1alpha1.StorageConfig, storageClassName *string) *serverv1alpha1.StorageConfig {
+	if storage == nil {
+		storage = &serverv1alpha1.StorageConfig{}
+	}
+	if storage.StorageClassName == nil {
+		storage.StorageClassName = storageClassName
+	}
+	return storage
+}
+
+// withResources is resources when they are set, or else a copy of preset
+func withResources(resources, preset *corev1.ResourceRequirements) *corev1.ResourceRequirements {
+	if resources != nil {
+		return resources
+	}
+	return preset.DeepCopy()
+}
+
+// withRegistry is image pulled from registry instead of the registry in its
+// name, e.g. docker.io/redis:6.2 from registry.example.com/mirror is
+// registry.example.com/mirror/redis:6.2
+func withRegistry(registry, image string) string {
+	registry = strings.TrimSuffix(registry, "/")
+	if registry == "" || strings.HasPrefix(image, registry+"/") {
+		return image
+	}
+	// The first part of the name is a registry when it is a host
+	if i := strings.Index(image, "/"); i >= 0 && strings.ContainsAny(image[:i], ".:") {
+		image = image[i+1:]
+	}
+	return registry + "/" + image
+}
+
+// useRegistry pulls the images of the containers of pod from registry
+func useRegistry(pod *corev1.PodSpec, registry string) {
+	for i := range pod.InitContainers {
+		pod.InitContainers[i].Image = withRegistry(registry, pod.InitContainers[i].Image)
+	}
+	for i := range pod.Containers {
+		pod.Containers[i].Image = withRegistry(registry, pod.Containers[i].Image)
+	}
+}
+
+// platformRequests reconciles every Bux when the BuxPlatform changes
+func (r *BuxReconciler) platformRequests(obj client.Object) []reconcile.Request {
+	if obj.GetName() != serverv1alpha1.BuxPlatformName {
+		return nil
+	}
+	ctx := context.Background()
+	buxes := serverv1alpha1.BuxList{}
+	if err := r.List(ctx, &buxes); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list the Buxes for the BuxPlatform")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(buxes.Items))
+	for i := range buxes.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: buxes.Items[i].Name, Namespace: buxes.Items[i].Namespace},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/pointer"
+)
+
+func TestPlatformDefaultsAreLayeredBeneathTheSpec(t *testing.T) {
+	platform := &serverv1alpha1.BuxPlatformSpec{
+		Domain:           "example.com",
+		ClusterIssuer:    "letsencrypt",
+		ImageRegistry:    "registry.example.com/mirror",
+		StorageClassName: pointer.StringPtr("fast"),
+		Resources: &serverv1alpha1.ResourcePresets{
+			Server: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			},
+		},
+	}
+	spec := serverv1alpha1.BuxSpec{
+		ClusterIssuer: "internal",
+		Postgresql: &serverv1alpha1.PostgresqlConfig{
+			Storage: &serverv1alpha1.StorageConfig{StorageClassName: pointer.StringPtr("standard")},
+		},
+	}
+	applyPlatformDefaults(&spec, platform)
+
+	if spec.Domain != "example.com" {
+		t.Errorf("domain = %s, want the platform's example.com", spec.Domain)
+	}
+	if spec.ClusterIssuer != "internal" {
+		t.Errorf("clusterIssuer = %s, want the Bux's internal", spec.ClusterIssuer)
+	}
+	if got := *spec.Postgresql.Storage.StorageClassName; got != "standard" {
+		t.Errorf("postgresql storage class = %s, want the Bux's standard", got)
+	}
+	if got := *spec.Redis.Storage.StorageClassName; got != "fast" {
+		t.Errorf("redis storage class = %s, want the platform's fast", got)
+	}
+	if spec.Server == nil || spec.Server.Resources == nil {
+		t.Fatal("server resources not defaulted")
+	}
+	spec.Server.Resources.Requests[corev1.ResourceCPU] = resource.MustParse("2")
+	if got := platform.Resources.Server.Requests[corev1.ResourceCPU]; got.String() != "1" {
+		t.Errorf("the preset was changed through the Bux to %s", got.String())
+	}
+
+	for image, want := range map[string]string{
+		"docker.io/redis:6.2":                   "registry.example.com/mirror/redis:6.2",
+		"quay.io/opstree/redis:v6.2.5":          "registry.example.com/mirror/opstree/redis:v6.2.5",
+		"galtbv/bux:latest":                     "registry.example.com/mirror/galtbv/bux:latest",
+		"registry.example.com/mirror/redis:6.2": "registry.example.com/mirror/redis:6.2",
+	} {
+		if got := withRegistry(spec.ImageRegistry, image); got != want {
+			t.Errorf("%s from the registry = %s, want %s", image, got, want)
+		}
+	}
+}
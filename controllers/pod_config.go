@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// applyPodConfig overrides the defaults of template with the fields pod sets,
+// the resources and probes go to the first container. The pod template patch
+// is applied last so it can change anything the controller built.
+func applyPodConfig(template *corev1.PodTemplateSpec, pod *serverv1alpha1.PodConfig) error {
+	if pod == nil {
+		return nil
+	}
+	container := &template.Spec.Containers[0]
+	if pod.Resources != nil {
+		container.Resources = *pod.Resources
+	}
+	if pod.LivenessProbe != nil {
+		container.LivenessProbe = pod.LivenessProbe
+	}
+	if pod.ReadinessProbe != nil {
+		container.ReadinessProbe = pod.ReadinessProbe
+	}
+	if pod.StartupProbe != nil {
+		container.StartupProbe = pod.StartupProbe
+	}
+	if pod.NodeSelector != nil {
+		template.Spec.NodeSelector = pod.NodeSelector
+	}
+	if pod.Tolerations != nil {
+		template.Spec.Tolerations = pod.Tolerations
+	}
+	if pod.Affinity != nil {
+		template.Spec.Affinity = pod.Affinity
+	}
+	if pod.TopologySpreadConstraints != nil {
+		template.Spec.TopologySpreadConstraints = pod.TopologySpreadConstraints
+	}
+	if pod.ServiceAccount != nil {
+		template.Spec.AutomountServiceAccountToken = automountServiceAccountToken(pod.ServiceAccount)
+	}
+	return serverv1alpha1.PatchPodTemplate(template, pod.PodTemplatePatch)
+}
+
+// serverPodConfig is the pod config of bux-server, nil when not set
+func serverPodConfig(bux *serverv1alpha1.Bux) *serverv1alpha1.PodConfig {
+	if bux.Spec.Server == nil {
+		return nil
+	}
+	return &bux.Spec.Server.PodConfig
+}
+
+// consolePodConfig is the pod config of bux-console, nil when not set
+func consolePodConfig(bux *serverv1alpha1.Bux) *serverv1alpha1.PodConfig {
+	if bux.Spec.ConsoleApp == nil {
+		return nil
+	}
+	return &bux.Spec.ConsoleApp.PodConfig
+}
+
+// consoleMongoPodConfig is the pod config of the bux-console mongodb, nil when not set
+func consoleMongoPodConfig(bux *serverv1alpha1.Bux) *serverv1alpha1.PodConfig {
+	if bux.Spec.ConsoleMongo == nil {
+		return nil
+	}
+	return &bux.Spec.ConsoleMongo.PodConfig
+}
+
+// postgresqlPodConfig is the pod config of postgresql, nil when not set
+func postgresqlPodConfig(bux *serverv1alpha1.Bux) *serverv1alpha1.PodConfig {
+	if bux.Spec.Postgresql == nil {
+		return nil
+	}
+	return &bux.Spec.Postgresql.PodConfig
+}
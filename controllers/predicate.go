@@ -2,19 +2,32 @@ package controllers
 
 import (
 	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
+// buxPredicate lets through the events of the Buxes, the BuxPlatform and the
+// objects that are ours when their spec changed
 func buxPredicate(scheme *runtime.Scheme) predicate.Predicate {
+	return predicate.And(oursPredicate(scheme), predicate.GenerationChangedPredicate{})
+}
+
+// workloadPredicate is buxPredicate for the deployments and statefulsets, that
+// also lets through the updates that change their readiness
+func workloadPredicate(scheme *runtime.Scheme) predicate.Predicate {
+	return predicate.And(oursPredicate(scheme),
+		predicate.Or(predicate.GenerationChangedPredicate{}, readinessChangedPredicate()))
+}
+
+// oursPredicate lets through the events of the objects that are ours
+func oursPredicate(scheme *runtime.Scheme) predicate.Predicate {
 	return predicate.Funcs{
 		// Update returns true if the Update event should be processed
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			if e.ObjectOld.GetGeneration() == e.ObjectNew.GetGeneration() {
-				return false
-			}
 			return isObjectOurs(scheme, e.ObjectOld)
 		},
 		// Create returns true if the Create event should be processed
@@ -28,6 +41,51 @@ func buxPredicate(scheme *runtime.Scheme) predicate.Predicate {
 	}
 }
 
+// readinessChangedPredicate lets through the updates of the deployments,
+// statefulsets and certificates that change whether they are ready
+func readinessChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return readiness(e.ObjectOld) != readiness(e.ObjectNew)
+		},
+	}
+}
+
+// readinessStatus is the part of the status of an object its readiness is
+// derived from
+type readinessStatus struct {
+	observedGeneration int64
+	replicas           int32
+	updatedReplicas    int32
+	readyReplicas      int32
+	availableReplicas  int32
+	ready              bool
+}
+
+func readiness(object client.Object) readinessStatus {
+	switch o := object.(type) {
+	case *appsv1.Deployment:
+		return readinessStatus{
+			observedGeneration: o.Status.ObservedGeneration,
+			replicas:           o.Status.Replicas,
+			updatedReplicas:    o.Status.UpdatedReplicas,
+			readyReplicas:      o.Status.ReadyReplicas,
+			availableReplicas:  o.Status.AvailableReplicas,
+		}
+	case *appsv1.StatefulSet:
+		return readinessStatus{
+			observedGeneration: o.Status.ObservedGeneration,
+			replicas:           o.Status.Replicas,
+			updatedReplicas:    o.Status.UpdatedReplicas,
+			readyReplicas:      o.Status.ReadyReplicas,
+			availableReplicas:  o.Status.AvailableReplicas,
+		}
+	case *unstructured.Unstructured:
+		return readinessStatus{ready: certificateReady(o)}
+	}
+	return readinessStatus{}
+}
+
 // isObjectOurs returns true if the object is ours.
 // it first checks if the object has our group, version, and kind
 // else it will check for non-empty "OadpOperatorlabel" labels
@@ -40,7 +98,8 @@ func isObjectOurs(scheme *runtime.Scheme, object client.Object) bool {
 		return false
 	}
 	gvk := objGVKs[0]
-	if gvk.Group == serverv1alpha1.GroupVersion.Group && gvk.Version == serverv1alpha1.GroupVersion.Version && gvk.Kind == serverv1alpha1.Kind {
+	if gvk.Group == serverv1alpha1.GroupVersion.Group && gvk.Version == serverv1alpha1.GroupVersion.Version &&
+		(gvk.Kind == serverv1alpha1.Kind || gvk.Kind == serverv1alpha1.PlatformKind) {
 		return true
 	}
 	return object.GetLabels()[serverv1alpha1.BuxLabel] != ""
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestReadinessChangesOfWorkloadsAreNotFiltered(t *testing.T) {
+	scheme := testScheme(t)
+	old := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "shop",
+			Generation: 2,
+			Labels:     map[string]string{serverv1alpha1.BuxLabel: "true"},
+		},
+		Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1},
+	}
+	ready := old.DeepCopy()
+	ready.Status.ReadyReplicas = 1
+	ready.Status.AvailableReplicas = 1
+	annotated := old.DeepCopy()
+	annotated.Annotations = map[string]string{"deployment.kubernetes.io/revision": "3"}
+	foreign := ready.DeepCopy()
+	foreign.Labels = nil
+	foreignOld := old.DeepCopy()
+	foreignOld.Labels = nil
+
+	for _, test := range []struct {
+		name     string
+		old, new *appsv1.Deployment
+		spec     bool
+		workload bool
+	}{
+		{name: "pods became ready", old: old, new: ready, spec: false, workload: true},
+		{name: "annotated", old: old, new: annotated, spec: false, workload: false},
+		{name: "not ours", old: foreignOld, new: foreign, spec: false, workload: false},
+	} {
+		e := event.UpdateEvent{ObjectOld: test.old, ObjectNew: test.new}
+		if got := buxPredicate(scheme).Update(e); got != test.spec {
+			t.Errorf("%s: spec predicate = %t, want %t", test.name, got, test.spec)
+		}
+		if got := workloadPredicate(scheme).Update(e); got != test.workload {
+			t.Errorf("%s: workload predicate = %t, want %t", test.name, got, test.workload)
+		}
+	}
+}
+
+func TestReadyConditionWaitsForTheWorkloads(t *testing.T) {
+	bux := &serverv1alpha1.Bux{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"}}
+	labels := map[string]string{serverv1alpha1.BuxLabel: "true"}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: bux.Namespace, Labels: labels},
+		Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32Ptr(2)},
+		Status:     appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-postgresql", Namespace: bux.Namespace, Labels: labels},
+		Status:     appsv1.StatefulSetStatus{Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1},
+	}
+	scheme := testScheme(t)
+	if err := controllerutil.SetControllerReference(bux, dep, scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := controllerutil.SetControllerReference(bux, sts, scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := fakeRequest(t, bux, dep, sts)
+
+	if err := r.setReadyCondition(); err != nil {
+		t.Fatal(err)
+	}
+	condition := apimeta.FindStatusCondition(r.BuxStatus.Conditions, serverv1alpha1.ConditionReady)
+	if condition == nil || condition.Status != metav1.ConditionFalse ||
+		!strings.Contains(condition.Message, "deployment shop") || strings.Contains(condition.Message, "statefulset") {
+		t.Errorf("with one of two replicas available: %v", condition)
+	}
+
+	dep.Status.AvailableReplicas = 2
+	if err := r.Status().Update(r.Context, dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setReadyCondition(); err != nil {
+		t.Fatal(err)
+	}
+	if !apimeta.IsStatusConditionTrue(r.BuxStatus.Conditions, serverv1alpha1.ConditionReady) {
+		t.Errorf("with every replica available: %v", r.BuxStatus.Conditions)
+	}
+}
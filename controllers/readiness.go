@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setReadyCondition records whether the workloads of the Bux are available and
+// the certificates of its ingresses are issued
+func (r *BuxRequest) setReadyCondition() error {
+	var waiting []string
+	ours := []client.ListOption{
+		client.InNamespace(r.NamespacedName.Namespace),
+		client.HasLabels{serverv1alpha1.BuxLabel},
+	}
+
+	deployments := appsv1.DeploymentList{}
+	if err := r.List(r.Context, &deployments, ours...); err != nil {
+		return err
+	}
+	for i := range deployments.Items {
+		dep := &deployments.Items[i]
+		if metav1.IsControlledBy(dep, r.Bux) && !deploymentAvailable(dep) {
+			waiting = append(waiting, "deployment "+dep.Name)
+		}
+	}
+	statefulSets := appsv1.StatefulSetList{}
+	if err := r.List(r.Context, &statefulSets, ours...); err != nil {
+		return err
+	}
+	for i := range statefulSets.Items {
+		sts := &statefulSets.Items[i]
+		if metav1.IsControlledBy(sts, r.Bux) && !statefulSetReady(sts) {
+			waiting = append(waiting, "statefulset "+sts.Name)
+		}
+	}
+	if r.CertManager {
+		certificates, err := r.pendingCertificates(ours)
+		if err != nil {
+			return err
+		}
+		waiting = append(waiting, certificates...)
+	}
+
+	condition := metav1.Condition{
+		Type:    serverv1alpha1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  serverv1alpha1.ReadyReasonAvailable,
+		Message: "the workloads are available",
+	}
+	if len(waiting) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = serverv1alpha1.ReadyReasonUnavailable
+		condition.Message = fmt.Sprintf("waiting for %s", strings.Join(waiting, ", "))
+	}
+	r.setCondition(condition)
+	return nil
+}
+
+// pendingCertificates are the certificates cert-manager issues for the
+// ingresses of the Bux that are not ready yet
+func (r *BuxRequest) pendingCertificates(ours []client.ListOption) ([]string, error) {
+	ingresses := networkingv1.IngressList{}
+	if err := r.List(r.Context, &ingresses, ours...); err != nil {
+		return nil, err
+	}
+	var pending []string
+	for i := range ingresses.Items {
+		ingress := &ingresses.Items[i]
+		if !metav1.IsControlledBy(ingress, r.Bux) || ingress.Annotations[clusterIssuerAnnotation] == "" {
+			continue
+		}
+		// cert-manager names the certificate after its secret
+		for _, tls := range ingress.Spec.TLS {
+			certificate := newCertificate()
+			key := types.NamespacedName{Name: tls.SecretName, Namespace: ingress.Namespace}
+			err := r.Get(r.Context, key, certificate)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+			if err != nil || !certificateReady(certificate) {
+				pending = append(pending, "certificate "+tls.SecretName)
+			}
+		}
+	}
+	return pending, nil
+}
+
+// deploymentAvailable returns true if every replica of the deployment is
+// updated and available
+func deploymentAvailable(dep *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.ObservedGeneration >= dep.Generation &&
+		dep.Status.UpdatedReplicas == replicas &&
+		dep.Status.Replicas == replicas &&
+		dep.Status.AvailableReplicas == replicas
+}
+
+// statefulSetReady returns true if every replica of the statefulset is updated
+// and ready
+func statefulSetReady(sts *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		sts.Status.UpdatedReplicas == replicas &&
+		sts.Status.ReadyReplicas == replicas
+}
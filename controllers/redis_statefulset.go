@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	redisv1beta1 "github.com/murray-distributed-technologies/redis-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// redisImage is the redis of the built-in statefulset
+	redisImage = "docker.io/redis:6.2"
+
+	// redisUID is the redis user of the redis image
+	redisUID = 999
+)
+
+// RedisOperatorInstalled returns true if the redis CRD of the opstree redis
+// operator is served by the cluster
+func RedisOperatorInstalled(config *rest.Config) (bool, error) {
+	return resourceServed(config, redisv1beta1.GroupVersion.String(), "redis")
+}
+
+// resourceServed returns true if the cluster serves the resource of the group version
+func resourceServed(config *rest.Config, groupVersion, resource string) (bool, error) {
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return false, err
+	}
+	resources, err := client.ServerResourcesForGroupVersion(groupVersion)
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, apiResource := range resources.APIResources {
+		if apiResource.Name == resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReconcileRedisStatefulSet is the built-in redis, used when the redis operator
+// isn't installed. It has the name and pod labels the operator would use.
+func (r *BuxRequest) ReconcileRedisStatefulSet(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	sts := appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.redis(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentCache),
+		},
+	}
+	// Selectors are immutable, wait for the statefulset with the old one to be replaced
+	old := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: sts.Name, Namespace: sts.Namespace}}
+	if migrated, err := r.migrateSelector(bux, old, r.Names.selector(componentCache)); !migrated || err != nil {
+		return false, err
+	}
+	// old is the current statefulset, if there is one
+	err := r.apply(&sts, func() error {
+		return r.updateRedisStatefulSet(&sts, bux, old.Spec.VolumeClaimTemplates)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// updateRedisStatefulSet renders the redis statefulset, volumeClaimTemplates
+// are those of the current statefulset
+func (r *BuxRequest) updateRedisStatefulSet(sts *appsv1.StatefulSet, bux *serverv1alpha1.Bux,
+	volumeClaimTemplates []corev1.PersistentVolumeClaim,
+) error {
+	err := controllerutil.SetControllerReference(bux, sts, r.Scheme)
+	if err != nil {
+		return err
+	}
+	var storage *serverv1alpha1.StorageConfig
+	if bux.Spec.Redis != nil {
+		storage = bux.Spec.Redis.Storage
+	}
+	spec := defaultRedisStatefulSetSpec(r.Names, storage)
+	if len(volumeClaimTemplates) > 0 {
+		// The volume claim templates of a statefulset are immutable, storage
+		// changes are only picked up by new statefulsets
+		spec.VolumeClaimTemplates = volumeClaimTemplates
+	}
+	sts.Spec = *spec
+	useRegistry(&sts.Spec.Template.Spec, bux.Spec.ImageRegistry)
+	return nil
+}
+
+// ReconcileRedisService is the service of the built-in redis
+func (r *BuxRequest) ReconcileRedisService(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	svc := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.Names.redis(),
+			Namespace: r.NamespacedName.Namespace,
+			Labels:    r.getAppLabels(componentCache),
+		},
+	}
+	err := r.apply(&svc, func() error {
+		return r.updateRedisService(&svc, bux)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) updateRedisService(svc *corev1.Service, bux *serverv1alpha1.Bux) error {
+	err := controllerutil.SetControllerReference(bux, svc, r.Scheme)
+	if err != nil {
+		return err
+	}
+	svc.Spec = *defaultRedisServiceSpec(r.Names)
+	return nil
+}
+
+func defaultRedisServiceSpec(names buxNames) *corev1.ServiceSpec {
+	return &corev1.ServiceSpec{
+		Selector: names.selector(componentCache),
+		Type:     corev1.ServiceTypeClusterIP,
+		Ports: []corev1.ServicePort{
+			{
+				Name:       "redis-client",
+				Port:       int32(6379),
+				TargetPort: intstr.FromInt(6379),
+			},
+		},
+	}
+}
+
+func defaultRedisStatefulSetSpec(names buxNames, storage *serverv1alpha1.StorageConfig) *appsv1.StatefulSetSpec {
+	podLabels := names.labels(componentCache)
+	// the label the redis operator selects its pods by, for the network policy
+	podLabels["app"] = names.redis()
+	ping := corev1.ProbeHandler{
+		Exec: &corev1.ExecAction{
+			Command: []string{"redis-cli", "ping"},
+		},
+	}
+	return &appsv1.StatefulSetSpec{
+		Replicas:    pointer.Int32Ptr(1),
+		ServiceName: names.redis(),
+		Selector:    metav1.SetAsLabelSelector(names.selector(componentCache)),
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				CreationTimestamp: metav1.Time{},
+				Labels:            podLabels,
+			},
+			Spec: corev1.PodSpec{
+				SecurityContext:              restrictedPodSecurityContext(redisUID),
+				ServiceAccountName:           names.redisServiceAccount(),
+				AutomountServiceAccountToken: pointer.BoolPtr(false),
+				Containers: []corev1.Container{
+					{
+						Name:                     "redis",
+						Image:                    redisImage,
+						Args:                     []string{"--appendonly", "yes", "--dir", "/data"},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						SecurityContext:          restrictedSecurityContext(),
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								"cpu":    resource.MustParse("101m"),
+								"memory": resource.MustParse("128Mi"),
+							},
+							Requests: corev1.ResourceList{
+								"cpu":    resource.MustParse("101m"),
+								"memory": resource.MustParse("128Mi"),
+							},
+						},
+						Ports: []corev1.ContainerPort{
+							{
+								ContainerPort: 6379,
+								Protocol:      corev1.ProtocolTCP,
+							},
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:        ping,
+							InitialDelaySeconds: 5,
+							PeriodSeconds:       10,
+							TimeoutSeconds:      5,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:        ping,
+							InitialDelaySeconds: 30,
+							PeriodSeconds:       10,
+							TimeoutSeconds:      5,
+							FailureThreshold:    6,
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{
+								Name:      "data",
+								MountPath: "/data",
+							},
+						},
+					},
+				},
+			},
+		},
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "data",
+				},
+				Spec: *defaultPVCSpec(storage, "1Gi"),
+			},
+		},
+	}
+}
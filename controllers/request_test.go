@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	redisv1beta1 "github.com/murray-distributed-technologies/redis-operator/api/v1beta1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testScheme has the types of the Buxes and their objects
+func testScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := serverv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := redisv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// fakeClient is a fake cluster holding objs
+func fakeClient(t *testing.T, objs ...client.Object) applyClient {
+	t.Helper()
+	return applyClient{fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objs...).Build()}
+}
+
+// fakeRequest reconciles bux against a fake cluster holding objs
+func fakeRequest(t *testing.T, bux *serverv1alpha1.Bux, objs ...client.Object) *BuxRequest {
+	t.Helper()
+	c := fakeClient(t, objs...)
+	return &BuxRequest{
+		BuxReconciler:  &BuxReconciler{Client: c, APIReader: c, Scheme: c.Scheme()},
+		Log:            logr.Discard(),
+		Context:        context.Background(),
+		NamespacedName: types.NamespacedName{Name: bux.Name, Namespace: bux.Namespace},
+		Names:          buxNames{instance: bux.Name},
+		Bux:            bux,
+		BuxStatus:      &serverv1alpha1.BuxStatus{},
+	}
+}
+
+// applyClient creates or updates the objects that are applied, the fake client
+// can't apply them server-side
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.PatchOption,
+) error {
+	if patch != client.Apply {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	current := obj.DeepCopyObject().(client.Object)
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), current)
+	if k8serrors.IsNotFound(err) {
+		return c.Create(ctx, obj)
+	} else if err != nil {
+		return err
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	if err := keepStatus(current, obj); err != nil {
+		return err
+	}
+	return c.Update(ctx, obj)
+}
+
+// keepStatus sets the status of obj to the one of current, applying doesn't
+// write the status
+func keepStatus(current, obj client.Object) error {
+	from, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return err
+	}
+	to, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	if status, ok := from["status"]; ok {
+		to["status"] = status
+	} else {
+		delete(to, "status")
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(to, obj)
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// defaultUID runs the images that don't have a non-root user of their own
+	defaultUID = 1000
+
+	// mongoUID is the mongodb user of the mongo image
+	mongoUID = 999
+
+	// postgresqlUID is the postgres user of the postgresql image
+	postgresqlUID = 26
+)
+
+// restrictedPodSecurityContext runs the pod as uid under the restricted Pod
+// Security Standard, its volumes are handed to the group of uid by the kubelet
+func restrictedPodSecurityContext(uid int64) *corev1.PodSecurityContext {
+	fsGroupChangePolicy := corev1.FSGroupChangeOnRootMismatch
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot:        pointer.BoolPtr(true),
+		RunAsUser:           pointer.Int64Ptr(uid),
+		RunAsGroup:          pointer.Int64Ptr(uid),
+		FSGroup:             pointer.Int64Ptr(uid),
+		FSGroupChangePolicy: &fsGroupChangePolicy,
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// restrictedSecurityContext is the container security context the restricted
+// Pod Security Standard requires
+func restrictedSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: pointer.BoolPtr(false),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+}
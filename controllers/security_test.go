@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+)
+
+func TestPodSpecsAreRestricted(t *testing.T) {
+	postgresql := &serverv1alpha1.Bux{
+		Spec: serverv1alpha1.BuxSpec{
+			Configuration: &serverv1alpha1.BuxConfig{Datastore: "postgresql"},
+			Backup: &serverv1alpha1.BackupConfig{
+				Schedule: "0 2 * * *",
+				S3:       &serverv1alpha1.S3Config{Bucket: "bux", CredentialsSecret: "bux-backup"},
+			},
+			RestoreFrom: &serverv1alpha1.RestoreConfig{
+				S3: &serverv1alpha1.S3Config{Bucket: "bux", CredentialsSecret: "bux-backup"},
+			},
+		},
+	}
+	mongodb := postgresql.DeepCopy()
+	mongodb.Spec.Configuration.Datastore = "mongodb"
+	names := buxNames{instance: "bux"}
+
+	podSpecs := map[string]*corev1.PodSpec{
+		"bux":                    &defaultDeploymentSpec(names, latestVersion, nil).Template.Spec,
+		"bux-console":            &defaultConsoleDeploymentSpec(names, "").Template.Spec,
+		"bux-console-mongo":      &defaultConsoleMongoDeploymentSpec(names).Template.Spec,
+		"bux-postgresql":         &defaultPostgresqlStatefulSetSpec(names).Template.Spec,
+		"bux-backup postgresql":  &defaultBackupCronJobSpec(names, postgresql).JobTemplate.Spec.Template.Spec,
+		"bux-backup mongodb":     &defaultBackupCronJobSpec(names, mongodb).JobTemplate.Spec.Template.Spec,
+		"bux-restore postgresql": &defaultRestoreJobSpec(names, postgresql).Template.Spec,
+		"bux-restore mongodb":    &defaultRestoreJobSpec(names, mongodb).Template.Spec,
+		"bux-migrate":            &defaultMigrationJobSpec(names, buxImage(latestVersion)).Template.Spec,
+		"redis-standalone":       &defaultRedisStatefulSetSpec(names, nil).Template.Spec,
+		"bux-maintenance":        &defaultMaintenanceDeploymentSpec(names).Template.Spec,
+	}
+	// the checks of the Pod Security admission controller
+	evaluator, err := policy.NewEvaluator(policy.DefaultChecks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restricted := psaapi.LevelVersion{Level: psaapi.LevelRestricted, Version: psaapi.LatestVersion()}
+	for name, spec := range podSpecs {
+		for _, result := range evaluator.EvaluatePod(restricted, &metav1.ObjectMeta{}, spec) {
+			if !result.Allowed {
+				t.Errorf("%s: %s: %s", name, result.ForbiddenReason, result.ForbiddenDetail)
+			}
+		}
+	}
+}
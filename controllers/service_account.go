@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// componentServiceAccount is the ServiceAccount the pods of a component run as
+type componentServiceAccount struct {
+	name      func(names buxNames) string
+	component component
+	config    func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig
+	// when is whether the component runs, it always does when nil. The step
+	// that runs the component removes the service account when it doesn't.
+	when func(bux *serverv1alpha1.Bux) bool
+}
+
+// serviceAccounts are the service accounts of the components, none of them are
+// bound to any role since the pods don't use the Kubernetes API
+var serviceAccounts = []componentServiceAccount{
+	{name: buxNames.server, component: componentServer, config: func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		return podServiceAccountConfig(serverPodConfig(bux))
+	}},
+	{name: buxNames.console, component: componentConsole, config: func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		return podServiceAccountConfig(consolePodConfig(bux))
+	}, when: consoleEnabled},
+	{name: buxNames.consoleMongo, component: componentConsoleMongo, config: func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		return podServiceAccountConfig(consoleMongoPodConfig(bux))
+	}, when: consoleEnabled},
+	{name: buxNames.postgresql, component: componentDatastore, config: func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		return podServiceAccountConfig(postgresqlPodConfig(bux))
+	}},
+	{name: buxNames.redisServiceAccount, component: componentCache, config: func(_ *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		return nil
+	}, when: redisEnabled},
+	{name: buxNames.backup, component: componentBackup, config: func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		if bux.Spec.Backup == nil {
+			return nil
+		}
+		return bux.Spec.Backup.ServiceAccount
+	}, when: backupEnabled},
+	{name: buxNames.restore, component: componentRestore, config: func(bux *serverv1alpha1.Bux) *serverv1alpha1.ServiceAccountConfig {
+		if bux.Spec.RestoreFrom == nil {
+			return nil
+		}
+		return bux.Spec.RestoreFrom.ServiceAccount
+	}, when: restoreEnabled},
+}
+
+// ReconcileServiceAccounts are the service accounts of the components
+func (r *BuxRequest) ReconcileServiceAccounts(_ logr.Logger) (bool, error) {
+	bux := r.Bux
+	for _, serviceAccount := range serviceAccounts {
+		if serviceAccount.when != nil && !serviceAccount.when(bux) {
+			continue
+		}
+		sa := corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      serviceAccount.name(r.Names),
+				Namespace: r.NamespacedName.Namespace,
+				Labels:    r.getAppLabels(serviceAccount.component),
+			},
+		}
+		config := serviceAccount.config(bux)
+		err := r.apply(&sa, func() error {
+			return r.updateServiceAccount(&sa, bux, config)
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (r *BuxRequest) updateServiceAccount(sa *corev1.ServiceAccount, bux *serverv1alpha1.Bux,
+	config *serverv1alpha1.ServiceAccountConfig,
+) error {
+	err := controllerutil.SetControllerReference(bux, sa, r.Scheme)
+	if err != nil {
+		return err
+	}
+	sa.AutomountServiceAccountToken = automountServiceAccountToken(config)
+	if config != nil {
+		// Only these are ours, the annotations of other controllers are kept
+		sa.Annotations = config.Annotations
+	}
+	return nil
+}
+
+// podServiceAccountConfig is the service account config of pod, nil when not set
+func podServiceAccountConfig(pod *serverv1alpha1.PodConfig) *serverv1alpha1.ServiceAccountConfig {
+	if pod == nil {
+		return nil
+	}
+	return pod.ServiceAccount
+}
+
+// automountServiceAccountToken is false unless the config asks for the token
+func automountServiceAccountToken(config *serverv1alpha1.ServiceAccountConfig) *bool {
+	if config == nil || config.AutomountServiceAccountToken == nil {
+		return pointer.BoolPtr(false)
+	}
+	return pointer.BoolPtr(*config.AutomountServiceAccountToken)
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// step is a reconcile function in the dependency graph of the objects of a Bux
+type step struct {
+	name      string
+	reconcile ReconcileFunc
+	// after are the steps that must be done, or skipped, before this one runs
+	after []string
+	// when is whether the spec enables the step, it always runs when nil
+	when func(*serverv1alpha1.Bux) bool
+	// remove deletes the objects of the step when the spec doesn't enable it
+	remove func(*serverv1alpha1.Bux) error
+}
+
+// steps is the dependency graph of the objects of a Bux
+func (r *BuxRequest) steps() []step {
+	return []step{
+		{name: "Validate", reconcile: r.Validate},
+		{name: "Config", reconcile: r.ReconcileConfig, after: []string{"Validate"}},
+		{name: "ServiceAccounts", reconcile: r.ReconcileServiceAccounts, after: []string{"Validate"}},
+		{name: "NetworkPolicies", reconcile: r.ReconcileNetworkPolicies, after: []string{"Validate"}},
+		{name: "Datastore", reconcile: r.ReconcileDatastore, after: []string{"ServiceAccounts"}},
+		{name: "Backup", reconcile: r.ReconcileBackup, after: []string{"Datastore"}, when: backupEnabled,
+			remove: r.removeBackup},
+		{name: "Redis", reconcile: r.ReconcileRedis, after: []string{"ServiceAccounts"}, when: redisEnabled,
+			remove: r.removeRedis},
+		{name: "Service", reconcile: r.ReconcileService, after: []string{"Validate"}},
+		{name: "Maintenance", reconcile: r.ReconcileMaintenance, after: []string{"Validate"}},
+		{name: "Ingress", reconcile: r.ReconcileIngress, after: []string{"Service", "Maintenance"}, when: domainSet,
+			remove: r.removing(r.Names.server(), &networkingv1.Ingress{})},
+		{name: "Restore", reconcile: r.ReconcileRestore, after: []string{"Datastore"}, when: restoreEnabled,
+			remove: r.removing(r.Names.restore(), &corev1.ServiceAccount{})},
+		{name: "Upgrade", reconcile: r.ReconcileUpgrade, after: []string{"Restore"}},
+		{name: "Migration", reconcile: r.ReconcileMigration, after: []string{"Config", "Redis", "Upgrade"},
+			when: autoMigrate},
+		{name: "Deployment", reconcile: r.ReconcileDeployment, after: []string{"Config", "ServiceAccounts", "Redis",
+			"Migration"}},
+		{name: "Autoscaling", reconcile: r.ReconcileAutoscaling, after: []string{"Deployment"}},
+		{name: "DisruptionBudget", reconcile: r.ReconcileDisruptionBudget, after: []string{"Deployment"}},
+		{name: "ConsoleMongoPVC", reconcile: r.ReconcileConsoleMongoPVC, after: []string{"Validate"},
+			when: consoleEnabled, remove: r.removeConsoleMongoPVC},
+		{name: "ConsoleMongoDeployment", reconcile: r.ReconcileConsoleMongoDeployment,
+			after: []string{"ServiceAccounts", "ConsoleMongoPVC"}, when: consoleEnabled,
+			remove: r.removing(r.Names.consoleMongo(), &appsv1.Deployment{}, &corev1.ServiceAccount{},
+				&networkingv1.NetworkPolicy{})},
+		{name: "ConsoleMongoService", reconcile: r.ReconcileConsoleMongoService, after: []string{"Validate"},
+			when: consoleEnabled, remove: r.removing(r.Names.consoleMongodb(), &corev1.Service{})},
+		{name: "ConsoleDeployment", reconcile: r.ReconcileConsoleDeployment,
+			after: []string{"ServiceAccounts", "ConsoleMongoService"}, when: consoleEnabled,
+			remove: r.removing(r.Names.console(), &appsv1.Deployment{}, &corev1.ServiceAccount{})},
+		{name: "ConsoleService", reconcile: r.ReconcileConsoleService, after: []string{"Validate"},
+			when: consoleEnabled, remove: r.removing(r.Names.console(), &corev1.Service{})},
+		{name: "ConsoleIngress", reconcile: r.ReconcileConsoleIngress, after: []string{"ConsoleService"},
+			when:   func(bux *serverv1alpha1.Bux) bool { return consoleEnabled(bux) && domainSet(bux) },
+			remove: r.removing(r.Names.console(), &networkingv1.Ingress{})},
+	}
+}
+
+// runSteps runs every step once the steps it comes after are done or skipped,
+// steps that don't depend on each other run concurrently. A step that waits or
+// fails blocks the steps after it, the others still run. The outcomes are
+// recorded in status.steps and the errors of the failed steps are returned.
+func (r *BuxRequest) runSteps(steps []step) error {
+	index := make(map[string]int, len(steps))
+	for i, s := range steps {
+		index[s.name] = i
+	}
+	for _, s := range steps {
+		for _, name := range s.after {
+			if _, ok := index[name]; !ok {
+				return fmt.Errorf("step %s comes after the unknown step %s", s.name, name)
+			}
+		}
+	}
+
+	done := make([]chan struct{}, len(steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	outcomes := make([]serverv1alpha1.StepStatus, len(steps))
+	errs := make([]error, len(steps))
+	wg := sync.WaitGroup{}
+	for i := range steps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			after := make([]serverv1alpha1.StepStatus, 0, len(steps[i].after))
+			for _, name := range steps[i].after {
+				<-done[index[name]]
+				after = append(after, outcomes[index[name]])
+			}
+			outcomes[i], errs[i] = r.runStep(&steps[i], after)
+		}(i)
+	}
+	wg.Wait()
+
+	r.BuxStatus.Steps = outcomes
+	return utilerrors.NewAggregate(errs)
+}
+
+// runStep runs a step whose previous steps have the outcomes after
+func (r *BuxRequest) runStep(s *step, after []serverv1alpha1.StepStatus) (serverv1alpha1.StepStatus, error) {
+	outcome := serverv1alpha1.StepStatus{Name: s.name}
+	for _, previous := range after {
+		if previous.Phase != serverv1alpha1.StepPhaseDone && previous.Phase != serverv1alpha1.StepPhaseSkipped {
+			outcome.Phase = serverv1alpha1.StepPhaseBlocked
+			outcome.Message = fmt.Sprintf("%s is %s", previous.Name, previous.Phase)
+			return outcome, nil
+		}
+	}
+	if s.when != nil && !s.when(r.Bux) {
+		outcome.Phase = serverv1alpha1.StepPhaseSkipped
+		if s.remove == nil {
+			return outcome, nil
+		}
+		err := s.remove(r.Bux)
+		if err != nil {
+			outcome.Phase = serverv1alpha1.StepPhaseFailed
+			outcome.Message = err.Error()
+		}
+		return outcome, err
+	}
+	cont, err := s.reconcile(r.Log.WithValues("step", s.name))
+	switch {
+	case err != nil:
+		outcome.Phase = serverv1alpha1.StepPhaseFailed
+		outcome.Message = err.Error()
+	case !cont:
+		outcome.Phase = serverv1alpha1.StepPhaseWaiting
+	default:
+		outcome.Phase = serverv1alpha1.StepPhaseDone
+	}
+	return outcome, err
+}
+
+// backupEnabled returns true if scheduled backups are configured
+func backupEnabled(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Backup != nil
+}
+
+// redisEnabled returns true if the controller runs the redis of bux-server
+func redisEnabled(bux *serverv1alpha1.Bux) bool {
+	return !externalRedis(bux) && !liteProfile(bux)
+}
+
+// restoreEnabled returns true if there is a backup to restore from
+func restoreEnabled(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.RestoreFrom != nil
+}
+
+// autoMigrate returns true if the controller runs the datastore migrations
+func autoMigrate(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Configuration.AutoMigrate
+}
+
+// consoleEnabled returns true if the bux console is deployed
+func consoleEnabled(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Console
+}
+
+// domainSet returns true if the Bux is served on a domain
+func domainSet(bux *serverv1alpha1.Bux) bool {
+	return bux.Spec.Domain != ""
+}
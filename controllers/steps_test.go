@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	"github.com/go-logr/logr"
+)
+
+func TestStepsFormAnAcyclicGraph(t *testing.T) {
+	r := &BuxRequest{BuxReconciler: &BuxReconciler{}}
+	steps := make(map[string]step)
+	for _, s := range r.steps() {
+		if _, ok := steps[s.name]; ok {
+			t.Fatalf("step %s is declared twice", s.name)
+		}
+		steps[s.name] = s
+	}
+	// visiting are the steps on the path being walked, a step seen again is a cycle
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var walk func(name string, path []string)
+	walk = func(name string, path []string) {
+		s, ok := steps[name]
+		if !ok {
+			t.Fatalf("%v comes after the unknown step %s", path, name)
+		}
+		if visiting[name] {
+			t.Fatalf("steps %v form a cycle", append(path, name))
+		}
+		if visited[name] {
+			return
+		}
+		visiting[name] = true
+		if len(s.after) == 0 && name != "Validate" {
+			t.Errorf("step %s does not come after Validate", name)
+		}
+		for _, after := range s.after {
+			walk(after, append(path, name))
+		}
+		visiting[name] = false
+		visited[name] = true
+	}
+	for name := range steps {
+		walk(name, nil)
+	}
+}
+
+func TestStepOutcomes(t *testing.T) {
+	r := &BuxRequest{
+		BuxReconciler: &BuxReconciler{},
+		Log:           logr.Discard(),
+		Bux:           &serverv1alpha1.Bux{},
+		BuxStatus:     &serverv1alpha1.BuxStatus{},
+	}
+	done := func(logr.Logger) (bool, error) { return true, nil }
+	wait := func(logr.Logger) (bool, error) { return false, nil }
+	fail := func(logr.Logger) (bool, error) { return false, errors.New("broken") }
+	never := func(*serverv1alpha1.Bux) bool { return false }
+
+	err := r.runSteps([]step{
+		{name: "Validate", reconcile: done},
+		{name: "Console", reconcile: fail, after: []string{"Validate"}, when: never},
+		{name: "AfterConsole", reconcile: done, after: []string{"Console"}},
+		{name: "Restore", reconcile: wait, after: []string{"Validate"}},
+		{name: "AfterRestore", reconcile: done, after: []string{"Restore", "AfterConsole"}},
+		{name: "Redis", reconcile: fail, after: []string{"Validate"}},
+		{name: "AfterRedis", reconcile: done, after: []string{"Redis"}},
+		{name: "Service", reconcile: done, after: []string{"Validate"}},
+	})
+	if err == nil || err.Error() != "broken" {
+		t.Errorf("error = %v, want the error of Redis", err)
+	}
+	want := map[string]serverv1alpha1.StepPhase{
+		"Validate":     serverv1alpha1.StepPhaseDone,
+		"Console":      serverv1alpha1.StepPhaseSkipped,
+		"AfterConsole": serverv1alpha1.StepPhaseDone,
+		"Restore":      serverv1alpha1.StepPhaseWaiting,
+		"AfterRestore": serverv1alpha1.StepPhaseBlocked,
+		"Redis":        serverv1alpha1.StepPhaseFailed,
+		"AfterRedis":   serverv1alpha1.StepPhaseBlocked,
+		"Service":      serverv1alpha1.StepPhaseDone,
+	}
+	if len(r.BuxStatus.Steps) != len(want) {
+		t.Fatalf("%d step outcomes recorded, want %d", len(r.BuxStatus.Steps), len(want))
+	}
+	for _, outcome := range r.BuxStatus.Steps {
+		if outcome.Phase != want[outcome.Name] {
+			t.Errorf("step %s is %s, want %s", outcome.Name, outcome.Phase, want[outcome.Name])
+		}
+	}
+
+	err = r.runSteps([]step{
+		{name: "Validate", reconcile: done, after: []string{"Vaildate"}},
+	})
+	if err == nil {
+		t.Error("a step after an unknown step ran")
+	}
+}
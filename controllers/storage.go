@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// resizeRequeueInterval is how often we check on a volume that is being expanded
+const resizeRequeueInterval = 30 * time.Second
+
+// reconcilePVC creates the claim, or updates the only fields of an existing claim
+// that are mutable: labels, the owner and the requested size when it grows. The
+// storage class of a claim can't change, a different class is refused.
+// Storage problems are reported through the conditionType condition instead of
+// failing the reconcile, since they need an operator to act on them.
+func (r *BuxRequest) reconcilePVC(bux *serverv1alpha1.Bux, name string, c component, conditionType string,
+	spec *corev1.PersistentVolumeClaimSpec,
+) (bool, error) {
+	pvc := corev1.PersistentVolumeClaim{}
+	key := types.NamespacedName{Name: name, Namespace: r.NamespacedName.Namespace}
+	err := r.Get(r.Context, key, &pvc)
+	if k8serrors.IsNotFound(err) {
+		pvc = corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: r.NamespacedName.Namespace,
+				Labels:    r.getAppLabels(c),
+			},
+			Spec: *spec,
+		}
+		if err = controllerutil.SetControllerReference(bux, &pvc, r.Scheme); err != nil {
+			return false, err
+		}
+		if err = r.Create(r.Context, &pvc); err != nil {
+			return false, err
+		}
+		r.setStorageCondition(conditionType, metav1.ConditionTrue, serverv1alpha1.StorageReasonReady,
+			fmt.Sprintf("%s created", name))
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	original := pvc.DeepCopy()
+	if err = controllerutil.SetControllerReference(bux, &pvc, r.Scheme); err != nil {
+		return false, err
+	}
+	if pvc.Labels == nil {
+		pvc.Labels = make(map[string]string)
+	}
+	for k, v := range r.getAppLabels(c) {
+		pvc.Labels[k] = v
+	}
+
+	if class := spec.StorageClassName; class != nil &&
+		(pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != *class) {
+		r.setStorageCondition(conditionType, metav1.ConditionFalse, serverv1alpha1.StorageReasonClassChangeRefused,
+			fmt.Sprintf("%s cannot change its storage class from %q to %q", name,
+				pointer.StringDeref(pvc.Spec.StorageClassName, ""), *class))
+		return true, r.patchPVC(original, &pvc)
+	}
+
+	requested := spec.Resources.Requests[corev1.ResourceStorage]
+	current := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if requested.Cmp(current) < 0 {
+		r.setStorageCondition(conditionType, metav1.ConditionFalse, serverv1alpha1.StorageReasonShrinkRefused,
+			fmt.Sprintf("%s cannot shrink from %s to %s", name, current.String(), requested.String()))
+		return true, r.patchPVC(original, &pvc)
+	}
+	growing := requested.Cmp(current) > 0
+	if growing {
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = requested
+	}
+
+	err = r.patchPVC(original, &pvc)
+	if growing && (k8serrors.IsForbidden(err) || k8serrors.IsInvalid(err)) {
+		// the storage class does not allow volume expansion
+		r.setStorageCondition(conditionType, metav1.ConditionFalse, serverv1alpha1.StorageReasonExpansionUnsupported,
+			fmt.Sprintf("%s cannot be expanded to %s: %s", name, requested.String(), err.Error()))
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(requested) < 0 {
+		r.setStorageCondition(conditionType, metav1.ConditionFalse, serverv1alpha1.StorageReasonResizing,
+			fmt.Sprintf("%s is being expanded from %s to %s", name, capacity.String(), requested.String()))
+		r.requeueAfter(resizeRequeueInterval)
+		return true, nil
+	}
+	r.setStorageCondition(conditionType, metav1.ConditionTrue, serverv1alpha1.StorageReasonReady,
+		fmt.Sprintf("%s has the requested size", name))
+	return true, nil
+}
+
+// patchPVC sends the changes made to pvc since original, if there are any
+func (r *BuxRequest) patchPVC(original, pvc *corev1.PersistentVolumeClaim) error {
+	if equality.Semantic.DeepEqual(original, pvc) {
+		return nil
+	}
+	return r.Patch(r.Context, pvc, client.MergeFrom(original))
+}
+
+func (r *BuxRequest) setStorageCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	r.setCondition(metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// defaultPVCSpec is a read-write-once claim of the configured class and size,
+// size is used when the storage config does not set one
+func defaultPVCSpec(storage *serverv1alpha1.StorageConfig, size string) *corev1.PersistentVolumeClaimSpec {
+	quantity := resource.MustParse(size)
+	var storageClassName *string
+	if storage != nil {
+		if storage.Size != nil {
+			quantity = *storage.Size
+		}
+		storageClassName = storage.StorageClassName
+	}
+	return &corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{
+			corev1.ReadWriteOnce,
+		},
+		StorageClassName: storageClassName,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: quantity,
+			},
+		},
+	}
+}
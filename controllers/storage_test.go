@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"testing"
+
+	serverv1alpha1 "github.com/BuxOrg/bux-kube-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestReconcilingAnExistingVolume(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		storage serverv1alpha1.StorageConfig
+		reason  string
+		size    string
+		class   string
+	}{
+		{name: "unchanged", storage: serverv1alpha1.StorageConfig{Size: quantity("2Gi")},
+			reason: serverv1alpha1.StorageReasonReady, size: "2Gi", class: "standard"},
+		{name: "expand", storage: serverv1alpha1.StorageConfig{Size: quantity("5Gi")},
+			reason: serverv1alpha1.StorageReasonResizing, size: "5Gi", class: "standard"},
+		{name: "shrink", storage: serverv1alpha1.StorageConfig{Size: quantity("1Gi")},
+			reason: serverv1alpha1.StorageReasonShrinkRefused, size: "2Gi", class: "standard"},
+		{name: "class change", storage: serverv1alpha1.StorageConfig{
+			Size: quantity("5Gi"), StorageClassName: pointer.StringPtr("fast"),
+		}, reason: serverv1alpha1.StorageReasonClassChangeRefused, size: "2Gi", class: "standard"},
+		{name: "same class", storage: serverv1alpha1.StorageConfig{
+			Size: quantity("2Gi"), StorageClassName: pointer.StringPtr("standard"),
+		}, reason: serverv1alpha1.StorageReasonReady, size: "2Gi", class: "standard"},
+	} {
+		bux := &serverv1alpha1.Bux{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments", UID: "shop"}}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop-postgresql", Namespace: bux.Namespace},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: pointer.StringPtr("standard"),
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")},
+				},
+			},
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")},
+			},
+		}
+		r := fakeRequest(t, bux, pvc)
+		done, err := r.reconcilePVC(bux, pvc.Name, componentDatastore, serverv1alpha1.ConditionPostgresqlStorageReady,
+			defaultPVCSpec(&test.storage, "1Gi"))
+		if err != nil || !done {
+			t.Fatalf("%s: done = %t, %v", test.name, done, err)
+		}
+		condition := apimeta.FindStatusCondition(r.BuxStatus.Conditions, serverv1alpha1.ConditionPostgresqlStorageReady)
+		if condition == nil || condition.Reason != test.reason {
+			t.Errorf("%s: condition is %v, want reason %s", test.name, condition, test.reason)
+		}
+		got := corev1.PersistentVolumeClaim{}
+		if err = r.Get(r.Context, types.NamespacedName{Name: pvc.Name, Namespace: bux.Namespace}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if size := got.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(resource.MustParse(test.size)) != 0 {
+			t.Errorf("%s: requested size is %s, want %s", test.name, size.String(), test.size)
+		}
+		if class := pointer.StringDeref(got.Spec.StorageClassName, ""); class != test.class {
+			t.Errorf("%s: storage class is %s, want %s", test.name, class, test.class)
+		}
+		if !metav1.IsControlledBy(&got, bux) {
+			t.Errorf("%s: the volume was not adopted", test.name)
+		}
+	}
+}
+
+func TestStorageClassChangesAreRefused(t *testing.T) {
+	old := &serverv1alpha1.Bux{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "payments"},
+		Spec: serverv1alpha1.BuxSpec{
+			Postgresql: &serverv1alpha1.PostgresqlConfig{
+				Storage: &serverv1alpha1.StorageConfig{StorageClassName: pointer.StringPtr("standard")},
+			},
+		},
+	}
+	resized := old.DeepCopy()
+	resized.Spec.Postgresql.Storage.Size = quantity("5Gi")
+	if err := resized.ValidateUpdate(old); err != nil {
+		t.Errorf("resizing: %v", err)
+	}
+	moved := old.DeepCopy()
+	moved.Spec.Postgresql.Storage.StorageClassName = pointer.StringPtr("fast")
+	if err := moved.ValidateUpdate(old); err == nil {
+		t.Error("the storage class of the postgresql volume was changed")
+	}
+	unset := old.DeepCopy()
+	unset.Spec.Postgresql.Storage = nil
+	if err := unset.ValidateUpdate(old); err == nil {
+		t.Error("the storage class of the postgresql volume was unset")
+	}
+	// the console mongo volume doesn't exist yet
+	withConsole := old.DeepCopy()
+	withConsole.Spec.ConsoleMongo = &serverv1alpha1.ConsoleMongoConfig{
+		Storage: &serverv1alpha1.StorageConfig{StorageClassName: pointer.StringPtr("fast")},
+	}
+	if err := withConsole.ValidateUpdate(old); err != nil {
+		t.Errorf("turning the console on: %v", err)
+	}
+}
+
+func quantity(s string) *resource.Quantity {
+	q := resource.MustParse(s)
+	return &q
+}
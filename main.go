@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,6 +29,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -56,11 +58,18 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var watchNamespaces string
+	var maxConcurrentReconciles int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", os.Getenv("WATCH_NAMESPACES"),
+		"Comma separated list of the namespaces to reconcile Buxes in, all namespaces when empty. "+
+			"Defaults to $WATCH_NAMESPACES.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of Buxes that are reconciled at the same time.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -69,26 +78,70 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "1b6af390.getbux.io",
-	})
+	}
+	// The cache only lists and watches the namespaces we reconcile, so a
+	// Role in each of them is enough
+	namespaces := splitNamespaces(watchNamespaces)
+	switch len(namespaces) {
+	case 0:
+		setupLog.Info("watching all namespaces")
+	case 1:
+		setupLog.Info("watching a single namespace", "namespace", namespaces[0])
+		options.Namespace = namespaces[0]
+	default:
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	redisOperator, err := controllers.RedisOperatorInstalled(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to discover the redis operator")
+		os.Exit(1)
+	}
+	if !redisOperator {
+		setupLog.Info("redis operator not installed, redis will run as a statefulset")
+	}
+	certManager, err := controllers.CertManagerInstalled(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to discover cert-manager")
+		os.Exit(1)
+	}
+	if !certManager {
+		setupLog.Info("cert-manager not installed, certificates are not part of the readiness of a Bux")
+	}
+
 	if err = (&controllers.BuxReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		APIReader:               mgr.GetAPIReader(),
+		Scheme:                  mgr.GetScheme(),
+		RedisOperator:           redisOperator,
+		CertManager:             certManager,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Bux")
 		os.Exit(1)
 	}
+	// the webhook needs a serving certificate, config/webhook-enabled has
+	// cert-manager issue it
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		if err = (&serverv1alpha1.Bux{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Bux")
+			os.Exit(1)
+		}
+	}
 	if err = (&controllers.AgentReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
@@ -113,3 +166,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitNamespaces parses the comma separated --watch-namespaces
+func splitNamespaces(list string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(list, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}